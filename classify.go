@@ -0,0 +1,144 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Classifier classifies driver specific errors. It returns ERR_Unknown for
+// errors it does not recognize. Errors returned by the library are wrapped in
+// an *Error, so classifiers must use errors.As instead of type assertions to
+// find the driver error.
+type Classifier func(err error) ErrorClass
+
+// classifiers holds the registered classifiers.
+var classifiers = struct {
+	sync.RWMutex
+	list []Classifier
+}{}
+
+// RegisterClassifier registers a classifier for the errors of a driver.
+// Classifiers are not bound to a driver name, and every registered classifier
+// is tried on every error, so a classifier must only recognize the error types
+// of its own driver. Classifiers should be registered alongside the driver,
+// before calling Open. The function is thread safe.
+func RegisterClassifier(c Classifier) {
+	if c == nil {
+		panic("classifier is nil")
+	}
+
+	classifiers.Lock()
+	classifiers.list = append(classifiers.list, c)
+	classifiers.Unlock()
+}
+
+// Classify returns the class of an error. The registered classifiers are tried
+// in the order they were registered, since their driver specific error types
+// do not overlap. If none recognizes the error, errors exposing a SQLSTATE code
+// through a SQLState method, such as those of lib/pq and pgx, and common
+// connection errors are classified.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ERR_Unknown
+	}
+
+	classifiers.RLock()
+	list := classifiers.list
+	classifiers.RUnlock()
+
+	for _, c := range list {
+		if cls := c(err); cls != ERR_Unknown {
+			return cls
+		}
+	}
+
+	var se interface{ SQLState() string }
+	if errors.As(err, &se) {
+		if cls := classifySQLState(se.SQLState()); cls != ERR_Unknown {
+			return cls
+		}
+	}
+	if isConnectionError(err) {
+		return ERR_Connection
+	}
+	return ERR_Unknown
+}
+
+// classifySQLState returns the class of a SQLSTATE code.
+func classifySQLState(code string) ErrorClass {
+	switch {
+	case code == "23505":
+		return ERR_UniqueViolation
+	case code == "23503":
+		return ERR_ForeignKeyViolation
+	case code == "23502":
+		return ERR_NotNullViolation
+	case code == "40P01":
+		return ERR_Deadlock
+	case code == "40001":
+		return ERR_SerializationFailure
+	case strings.HasPrefix(code, "08"):
+		return ERR_Connection
+	default:
+		return ERR_Unknown
+	}
+}
+
+// isConnectionError reports whether an error is caused by a broken or refused
+// connection to the database. Cancelled calls and timeouts are not connection
+// errors, even though context.DeadlineExceeded implements net.Error.
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var nerr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &nerr)
+}
+
+// IsUniqueViolation reports whether an error is a unique constraint violation.
+func IsUniqueViolation(err error) bool {
+	return Classify(err) == ERR_UniqueViolation
+}
+
+// IsForeignKeyViolation reports whether an error is a foreign key constraint
+// violation.
+func IsForeignKeyViolation(err error) bool {
+	return Classify(err) == ERR_ForeignKeyViolation
+}
+
+// IsNotNullViolation reports whether an error is a not null constraint
+// violation.
+func IsNotNullViolation(err error) bool {
+	return Classify(err) == ERR_NotNullViolation
+}
+
+// IsDeadlock reports whether an error is caused by a deadlock.
+func IsDeadlock(err error) bool {
+	return Classify(err) == ERR_Deadlock
+}
+
+// IsSerializationFailure reports whether an error is a serialization failure
+// of a transaction.
+func IsSerializationFailure(err error) bool {
+	return Classify(err) == ERR_SerializationFailure
+}
+
+// IsConnectionError reports whether an error is caused by a broken or refused
+// connection to the database.
+func IsConnectionError(err error) bool {
+	return Classify(err) == ERR_Connection
+}
@@ -0,0 +1,151 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// vendorError is a driver specific error recognized by a registered
+// classifier.
+type vendorError struct {
+	code int
+}
+
+func (e *vendorError) Error() string {
+	return fmt.Sprintf("vendor error %d", e.code)
+}
+
+func init() {
+	RegisterClassifier(func(err error) ErrorClass {
+		var verr *vendorError
+		if errors.As(err, &verr) && verr.code == 1062 {
+			return ERR_UniqueViolation
+		}
+		return ERR_Unknown
+	})
+}
+
+func TestClassifySQLState(t *testing.T) {
+	tests := []struct {
+		code string
+		want ErrorClass
+	}{
+		{code: "23505", want: ERR_UniqueViolation},
+		{code: "23503", want: ERR_ForeignKeyViolation},
+		{code: "23502", want: ERR_NotNullViolation},
+		{code: "40P01", want: ERR_Deadlock},
+		{code: "40001", want: ERR_SerializationFailure},
+		{code: "08000", want: ERR_Connection},
+		{code: "08006", want: ERR_Connection},
+		{code: "23000", want: ERR_Unknown},
+		{code: "", want: ERR_Unknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := classifySQLState(tt.code); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{name: "nil", err: nil, want: ERR_Unknown},
+		{name: "unknown", err: errors.New("unknown"), want: ERR_Unknown},
+		{name: "sqlstate", err: sqlStateError("23505"), want: ERR_UniqueViolation},
+		{name: "wrapped sqlstate", err: fmt.Errorf("insert: %w", sqlStateError("40001")), want: ERR_SerializationFailure},
+		{name: "unknown sqlstate", err: sqlStateError("42601"), want: ERR_Unknown},
+		{name: "connection sqlstate", err: sqlStateError("08003"), want: ERR_Connection},
+		{name: "registered", err: &vendorError{1062}, want: ERR_UniqueViolation},
+		{name: "registered unknown", err: &vendorError{1}, want: ERR_Unknown},
+		{name: "bad connection", err: driver.ErrBadConn, want: ERR_Connection},
+		{name: "connection done", err: sql.ErrConnDone, want: ERR_Connection},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, want: ERR_Connection},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, want: ERR_Connection},
+		{name: "reset", err: syscall.ECONNRESET, want: ERR_Connection},
+		{name: "broken pipe", err: syscall.EPIPE, want: ERR_Connection},
+		{name: "network timeout", err: timeout, want: ERR_Connection},
+		{name: "canceled", err: context.Canceled, want: ERR_Unknown},
+		{name: "deadline", err: context.DeadlineExceeded, want: ERR_Unknown},
+		{name: "wrapped deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: ERR_Unknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+
+			// Errors of the library are classified by their cause.
+			werr := newError(FN_Exec, SRC_Database, "", nil, time.Now(), tt.err)
+			if got := Classify(werr); got != tt.want {
+				t.Fatalf("got %d for the wrapped error, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyHelpers(t *testing.T) {
+	tests := []struct {
+		name string
+		is   func(error) bool
+		err  error
+	}{
+		{name: "unique", is: IsUniqueViolation, err: sqlStateError("23505")},
+		{name: "foreign key", is: IsForeignKeyViolation, err: sqlStateError("23503")},
+		{name: "not null", is: IsNotNullViolation, err: sqlStateError("23502")},
+		{name: "deadlock", is: IsDeadlock, err: sqlStateError("40P01")},
+		{name: "serialization", is: IsSerializationFailure, err: sqlStateError("40001")},
+		{name: "connection", is: IsConnectionError, err: driver.ErrBadConn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.is(tt.err) {
+				t.Fatalf("%v is not recognized", tt.err)
+			}
+			if tt.is(errors.New("other")) {
+				t.Fatal("other error is recognized")
+			}
+		})
+	}
+}
+
+func TestContextClass(t *testing.T) {
+	db, _ := openFake(t)
+
+	got := []ErrorClass{}
+	db.Use(func(ctx context.Context, qctx *Context) {
+		got = append(got, qctx.Class())
+		qctx.Next()
+		got = append(got, qctx.Class())
+	}, []Function{FN_Exec})
+	db.Use(func(ctx context.Context, qctx *Context) {
+		qctx.Error(&vendorError{1062})
+	}, []Function{FN_Exec})
+
+	if _, err := db.Exec("INSERT"); !IsUniqueViolation(err) {
+		t.Fatalf("got error %v, want unique violation", err)
+	}
+	if want := []ErrorClass{ERR_Unknown, ERR_UniqueViolation}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got classes %v, want %v", got, want)
+	}
+}
@@ -3,11 +3,13 @@ package sqlm
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 type Conn struct {
 	cn   *sql.Conn
-	mdws hndl
+	mdws *scope
+	db   *DB
 }
 
 // Connection returns the underlying *sql.Conn object.
@@ -15,38 +17,99 @@ func (cn *Conn) Connection() *sql.Conn {
 	return cn.cn
 }
 
+// Node returns the node identifying the connection and its parents.
+func (cn *Conn) Node() *Node {
+	return cn.mdws.node
+}
+
+// Session returns the session store of the connection.
+func (cn *Conn) Session() *Session {
+	return cn.mdws.session()
+}
+
+// Use attaches a middleware handler to specific sql functions of the
+// connection. The handler runs after the handlers inherited from the database
+// object and only applies to the connection, and the transactions and prepared
+// statements created from it. Options and panics are the same as in DB.Use.
+// The returned handle can be used to remove the handler. The function is
+// thread safe.
+func (cn *Conn) Use(
+	mdw func(context.Context, *Context),
+	fns []Function,
+	opts ...UseOption,
+) *Middleware {
+	return cn.mdws.use(mdw, fns, opts)
+}
+
+// Middlewares returns the middleware handlers that run for a sql function
+// called on the connection, in the order they run.
+func (cn *Conn) Middlewares(fn Function) []*Middleware {
+	return cn.mdws.middlewares(fn)
+}
+
 // BeginTx creates a new transaction *Tx object with options. It calls
 // sql.BeginTx and stores a *sql.Tx object internally. The transaction
-// inherits the middlewares of the database object.
+// inherits the middlewares of the connection.
 func (cn *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
 	mdws := cn.mdws.fnHndl(FN_Begin)
 	if len(mdws) == 0 {
+		start := time.Now()
 		if sqltx, err := cn.cn.BeginTx(ctx, opts); err != nil {
-			return nil, err
+			return nil, newError(FN_Begin, SRC_Connection, "", nil, start, err)
 		} else {
-			return &Tx{sqltx, cn.mdws, false}, nil
+			return newTx(sqltx, newScope(cn.mdws, SRC_Transaction), cn.db), nil
 		}
 	}
 
-	var tx *Tx
 	var err error
-	qctx := newContext(ctx, FN_Begin, SRC_Connection, "", nil, mdws)
+	qctx := newContext(ctx, FN_Begin, SRC_Connection, "", nil, cn.mdws, mdws)
 	qctx.fn = func() {
-		if t, e := cn.cn.BeginTx(ctx, opts); e != nil {
+		if t, e := cn.cn.BeginTx(qctx.ctx, opts); e != nil {
 			qctx.Error(e)
 		} else {
-			tx = &Tx{t, cn.mdws, false}
+			qctx.tx = newTx(t, newScope(cn.mdws, SRC_Transaction), cn.db)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return tx, err
+	err = qctx.resultErr(qctx.tx != nil)
+	return qctx.tx, err
+}
+
+// WithTx begins a transaction with options on the connection and runs fn with it.
+// The transaction is committed if fn returns nil, and rolled back if fn returns
+// an error or panics, in which case the panic is re-raised after the rollback.
+// Beginning, committing and rolling back run their middleware handlers. If the
+// handlers of FN_Commit do not complete the transaction, it is rolled back.
+// Errors of the transaction's hooks are not returned, and are reported by
+// HookErrors of the transaction passed to fn.
+func (cn *Conn) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) error {
+	_, err := withTx(ctx, cn, opts, fn)
+	return err
 }
 
 // Close returns the connection to the connection pool. It calls sql.Close.
 func (cn *Conn) Close() error {
-	return cn.cn.Close()
+	mdws := cn.mdws.fnHndl(FN_Close)
+	if len(mdws) == 0 {
+		start := time.Now()
+		return newError(FN_Close, SRC_Connection, "", nil, start, cn.cn.Close())
+	}
+
+	var err error
+	var done bool
+	ctx := context.Background()
+	qctx := newContext(ctx, FN_Close, SRC_Connection, "", nil, cn.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := cn.cn.Close(); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	err = qctx.resultErr(done)
+	return err
 }
 
 // ExecContext executes a query without returning any rows The args are for any
@@ -54,23 +117,24 @@ func (cn *Conn) Close() error {
 func (cn *Conn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
 	mdws := cn.mdws.fnHndl(FN_Exec)
 	if len(mdws) == 0 {
-		return cn.cn.ExecContext(ctx, query, args...)
+		start := time.Now()
+		res, err := cn.cn.ExecContext(ctx, query, args...)
+		return res, newError(FN_Exec, SRC_Connection, query, args, start, err)
 	}
 
-	var res sql.Result
 	var err error
-	qctx := newContext(ctx, FN_Exec, SRC_Connection, query, args, mdws)
+	qctx := newContext(ctx, FN_Exec, SRC_Connection, query, args, cn.mdws, mdws)
 	qctx.fn = func() {
-		if r, e := cn.cn.ExecContext(ctx, qctx.Query, qctx.Args...); e != nil {
+		if r, e := cn.cn.ExecContext(qctx.ctx, qctx.Query, qctx.Args...); e != nil {
 			qctx.Error(e)
 		} else {
-			res = r
+			qctx.result = r
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return res, err
+	err = qctx.err()
+	return qctx.execResult(), err
 }
 
 // PingContext verifies a connection to the database is still alive,
@@ -78,72 +142,91 @@ func (cn *Conn) ExecContext(ctx context.Context, query string, args ...any) (sql
 func (cn *Conn) PingContext(ctx context.Context) error {
 	mdws := cn.mdws.fnHndl(FN_Ping)
 	if len(mdws) == 0 {
-		return cn.cn.PingContext(ctx)
+		start := time.Now()
+		return newError(FN_Ping, SRC_Connection, "", nil, start, cn.cn.PingContext(ctx))
 	}
 
 	var err error
-	qctx := newContext(ctx, FN_Ping, SRC_Connection, "", nil, mdws)
+	var done bool
+	qctx := newContext(ctx, FN_Ping, SRC_Connection, "", nil, cn.mdws, mdws)
 	qctx.fn = func() {
-		if e := cn.cn.PingContext(ctx); e != nil {
+		done = true
+		if e := cn.cn.PingContext(qctx.ctx); e != nil {
 			qctx.Error(e)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
+	err = qctx.resultErr(done)
 	return err
 }
 
 // PrepareContext creates a prepared statement for later queries or executions.
 // It calls sql.PrepareContext and stores a *sql.Stmt object internally. The
-// statement inherits the middlewares of the database object.
+// statement inherits the middlewares of the connection.
 func (cn *Conn) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
 	mdws := cn.mdws.fnHndl(FN_Prepare)
 	if len(mdws) == 0 {
+		start := time.Now()
 		if sqlstmt, err := cn.cn.PrepareContext(ctx, query); err != nil {
-			return nil, err
+			return nil, newError(FN_Prepare, SRC_Connection, query, nil, start, err)
 		} else {
-			return &Stmt{sqlstmt, cn.mdws, query}, nil
+			return &Stmt{sqlstmt, newScope(cn.mdws, SRC_Statement), query}, nil
 		}
 	}
 
-	var stmt *Stmt
 	var err error
-	qctx := newContext(ctx, FN_Prepare, SRC_Connection, query, nil, mdws)
+	qctx := newContext(ctx, FN_Prepare, SRC_Connection, query, nil, cn.mdws, mdws)
 	qctx.fn = func() {
-		if s, e := cn.cn.PrepareContext(ctx, qctx.Query); e != nil {
+		if s, e := cn.cn.PrepareContext(qctx.ctx, qctx.Query); e != nil {
 			qctx.Error(e)
 		} else {
-			stmt = &Stmt{s, cn.mdws, query}
+			qctx.stmt = &Stmt{s, newScope(cn.mdws, SRC_Statement), query}
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return stmt, err
+	err = qctx.resultErr(qctx.stmt != nil)
+	return qctx.stmt, err
 }
 
 // QueryContext executes a query that returns rows, typically a SELECT.
 // The args are for any placeholder parameters in the query. It calls
 // sql.QueryContext
-func (cn *Conn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+func (cn *Conn) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
 	mdws := cn.mdws.fnHndl(FN_Query)
 	if len(mdws) == 0 {
-		return cn.cn.QueryContext(ctx, query, args...)
+		start := time.Now()
+		if sqlrows, err := cn.cn.QueryContext(ctx, query, args...); err != nil {
+			return nil, newError(FN_Query, SRC_Connection, query, args, start, err)
+		} else {
+			return newRows(ctx, sqlrows, newScope(cn.mdws, SRC_Rows), query), nil
+		}
 	}
 
-	var rows *sql.Rows
 	var err error
-	qctx := newContext(ctx, FN_Query, SRC_Connection, query, args, mdws)
+	qctx := newContext(ctx, FN_Query, SRC_Connection, query, args, cn.mdws, mdws)
 	qctx.fn = func() {
-		if r, e := cn.cn.QueryContext(ctx, qctx.Query, qctx.Args...); e != nil {
+		if r, e := cn.cn.QueryContext(qctx.ctx, qctx.Query, qctx.Args...); e != nil {
 			qctx.Error(e)
 		} else {
-			rows = r
+			qctx.rows = newRows(qctx.ctx, r, newScope(cn.mdws, SRC_Rows), qctx.Query)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return rows, err
+	err = qctx.resultErr(qctx.rows != nil)
+	return qctx.rows, err
+}
+
+// QueryRow calls QueryRowContext with context.Background, query and args.
+func (cn *Conn) QueryRow(query string, args ...any) *Row {
+	return cn.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext executes a query that is expected to return a single row.
+// It calls QueryContext, so it runs the handlers of FN_Query. Errors are
+// deferred until the row's Scan method is called.
+func (cn *Conn) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	return newRow(cn.QueryContext(ctx, query, args...))
 }
@@ -2,17 +2,25 @@ package sqlm
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"sync"
+	"time"
 )
 
+// ErrNoResult is returned by calls that were aborted by a middleware handler
+// without an error and without setting a result. See Context.Abort for details.
+var ErrNoResult = errors.New("sqlm: call aborted without a result")
+
 // handler describes a middleware handler's function signature.
 type handler func(context.Context, *Context)
 
 // Context is a collection of data used in the process of calling various sql
 // functions. It enables middleware handlers to modify the parameters of the
 // called sql functions or extend their functionality. Context DOES NOT
-// implement context.Context, and is not used for calling any underlying sql
-// functions.
+// implement context.Context, but it holds the context.Context used for calling
+// the underlying sql function, which handlers can replace with SetContext.
 type Context struct {
 	funct  Function
 	source Source
@@ -20,35 +28,61 @@ type Context struct {
 	Args   []any
 	fn     func()
 	errs   []error
+	start  time.Time
+
+	result sql.Result
+	rows   *Rows
+	tx     *Tx
+	stmt   *Stmt
+	drv    any
 
-	mdws   []handler
-	mdwIdx int
+	sc      *scope
+	mdws    []handler
+	mdwIdx  int
+	aborted bool
+	ambient bool
 
 	ctx    context.Context
 	mtx    *sync.Mutex
 	Values map[string]any
 }
 
-// newContext creates a new context from values.
+// newContext creates a new context from values. The middleware handlers are
+// filtered by their matchers once, before the chain starts.
 func newContext(
 	ctx context.Context,
 	funct Function,
 	source Source,
 	query string,
 	args []any,
-	mdws []handler,
+	sc *scope,
+	mdws []*Middleware,
 ) *Context {
-	return &Context{
+	qctx := &Context{
 		funct:  funct,
+		source: source,
 		Query:  query,
 		Args:   args,
 		errs:   make([]error, 0, 1),
-		mdws:   mdws,
+		start:  time.Now(),
+		sc:     sc,
+		mdws:   make([]handler, 0, len(mdws)),
 		mdwIdx: 0,
 		ctx:    ctx,
 		mtx:    &sync.Mutex{},
 		Values: map[string]any{},
 	}
+
+	if n := Attempt(ctx); n != 0 {
+		qctx.Values[AttemptKey] = n
+	}
+
+	for _, m := range mdws {
+		if m.match == nil || m.match(qctx) {
+			qctx.mdws = append(qctx.mdws, m.hndl)
+		}
+	}
+	return qctx
 }
 
 // Function returns which sql function is being executed in the operation.
@@ -61,6 +95,59 @@ func (ctx *Context) Source() Source {
 	return ctx.source
 }
 
+// Ambient returns whether the call was issued on a database object and routed
+// to a transaction stored in the context.Context with ContextWithTx. The source
+// of such calls is SRC_Transaction.
+func (ctx *Context) Ambient() bool {
+	return ctx.ambient
+}
+
+// Kind returns the statement kind of the query, derived from its leading
+// keyword.
+func (ctx *Context) Kind() Kind {
+	return queryKind(ctx.Query)
+}
+
+// Label returns the value of a label in the context.Context used for calling
+// the sql function.
+func (ctx *Context) Label(key string) (string, bool) {
+	return Label(ctx.ctx, key)
+}
+
+// Context returns the context.Context used for calling the sql function.
+func (ctx *Context) Context() context.Context {
+	return ctx.ctx
+}
+
+// SetContext replaces the context.Context used for calling the sql function.
+// The new context is passed to the remaining handlers on the chain and to the
+// underlying sql function. A nil context is ignored.
+func (ctx *Context) SetContext(c context.Context) {
+	if c != nil {
+		ctx.ctx = c
+	}
+}
+
+// Origin returns the node of the sql object the call was made on. Calls routed
+// to an ambient transaction originate from the transaction.
+func (ctx *Context) Origin() *Node {
+	return ctx.sc.node
+}
+
+// Lineage returns the chain of nodes from the database object down to the sql
+// object the call was made on, such as a statement prepared on a transaction
+// begun on a connection.
+func (ctx *Context) Lineage() []*Node {
+	return ctx.sc.node.Lineage()
+}
+
+// Session returns the session store of the transaction or connection the call
+// was made on, directly or through its prepared statements and rows. It
+// returns nil for calls made on the database object.
+func (ctx *Context) Session() *Session {
+	return ctx.sc.session()
+}
+
 // Lock locks the mutex within the context
 func (ctx *Context) Lock() {
 	ctx.mtx.Lock()
@@ -96,8 +183,13 @@ func (ctx *Context) Delete(key string) {
 	ctx.mtx.Unlock()
 }
 
-// Next calls the next handler on the middleware chain.
+// Next calls the next handler on the middleware chain. If the chain has been
+// aborted, Next does nothing.
 func (ctx *Context) Next() {
+	if ctx.aborted {
+		return
+	}
+
 	idx := ctx.mdwIdx
 	ctx.mdwIdx++
 	if idx < len(ctx.mdws) {
@@ -107,9 +199,82 @@ func (ctx *Context) Next() {
 	}
 }
 
-// Error appends an error to the list of errors in the context. Only the first
-// error will be returned in the sql function. If the sql function returned an
-// error, it will be added to the error list.
+// Abort stops the middleware chain. Handlers after the current one and the
+// underlying sql function will not be called. Handlers that called Next before
+// the abort still resume after it returns. The sql function returns the values
+// set on the context with SetResult, SetRows, SetTx or SetStmt, and the errors
+// added with Error. If no value and no error was set, the Exec functions return
+// driver.ResultNoRows, Next and NextResultSet return false, and Scan returns
+// nil, so handlers can fill the destinations in the args. The other functions,
+// including Ping, Close, Commit, Rollback and the savepoint functions, return
+// an *Error wrapping ErrNoResult, since the underlying call did not run. Commit
+// and Rollback return sql.ErrTxDone instead if a handler completed the
+// transaction.
+func (ctx *Context) Abort() {
+	ctx.aborted = true
+}
+
+// IsAborted returns whether the middleware chain has been aborted.
+func (ctx *Context) IsAborted() bool {
+	return ctx.aborted
+}
+
+// Result returns the sql.Result produced by the Exec functions. It is only
+// available after Next returns.
+func (ctx *Context) Result() sql.Result {
+	return ctx.result
+}
+
+// SetResult sets or replaces the sql.Result returned by the Exec functions.
+func (ctx *Context) SetResult(res sql.Result) {
+	ctx.result = res
+}
+
+// Rows returns the *Rows produced by the Query functions. It is only available
+// after Next returns.
+func (ctx *Context) Rows() *Rows {
+	return ctx.rows
+}
+
+// SetRows sets or replaces the *Rows returned by the Query functions.
+func (ctx *Context) SetRows(rows *Rows) {
+	ctx.rows = rows
+}
+
+// NewRows creates a *Rows object from a *sql.Rows object for the call, which
+// can be set with SetRows. The rows run the middleware handlers of the object
+// the call was made on. The *sql.Rows object can come from any database, such
+// as an in-memory one answering the call for a caching or mocking middleware.
+func (ctx *Context) NewRows(rs *sql.Rows) *Rows {
+	return newRows(ctx.ctx, rs, newScope(ctx.sc, SRC_Rows), ctx.Query)
+}
+
+// Tx returns the *Tx produced by the Begin functions. It is only available
+// after Next returns.
+func (ctx *Context) Tx() *Tx {
+	return ctx.tx
+}
+
+// SetTx sets or replaces the *Tx returned by the Begin functions.
+func (ctx *Context) SetTx(tx *Tx) {
+	ctx.tx = tx
+}
+
+// Stmt returns the *Stmt produced by the Prepare functions. It is only
+// available after Next returns.
+func (ctx *Context) Stmt() *Stmt {
+	return ctx.stmt
+}
+
+// SetStmt sets or replaces the *Stmt returned by the Prepare functions.
+func (ctx *Context) SetStmt(stmt *Stmt) {
+	ctx.stmt = stmt
+}
+
+// Error appends an error to the list of errors in the context. The sql function
+// returns an *Error with the first error as its cause and the rest as
+// additional errors. If the sql function returned an error, it will be added
+// to the error list.
 func (ctx *Context) Error(err error) {
 	ctx.errs = append(ctx.errs, err)
 }
@@ -119,11 +284,54 @@ func (ctx *Context) Errors() []error {
 	return ctx.errs
 }
 
-// fsterr returns the first error in the context or nil if empty.
-func (ctx *Context) fsterr() error {
-	if len(ctx.errs) != 0 {
-		return ctx.errs[0]
-	} else {
+// Class returns the class of the first error in the context, or ERR_Unknown
+// if there are no errors. See Classify for details.
+func (ctx *Context) Class() ErrorClass {
+	if len(ctx.errs) == 0 {
+		return ERR_Unknown
+	}
+	return Classify(ctx.errs[0])
+}
+
+// cause returns the first error in the context or nil if empty. Driver level
+// calls return it unwrapped, so consumers of a *sql.DB see the driver errors.
+func (ctx *Context) cause() error {
+	if len(ctx.errs) == 0 {
 		return nil
 	}
+	return ctx.errs[0]
+}
+
+// resultCause returns the first error in the context like cause. If the chain
+// produced no error and no result, it returns ErrNoResult.
+func (ctx *Context) resultCause(ok bool) error {
+	if !ok && len(ctx.errs) == 0 {
+		return ErrNoResult
+	}
+	return ctx.cause()
+}
+
+// execResult returns the sql.Result of the Exec functions. If the chain was
+// aborted without an error and without a result, it returns
+// driver.ResultNoRows.
+func (ctx *Context) execResult() sql.Result {
+	if ctx.result == nil && len(ctx.errs) == 0 {
+		return driver.ResultNoRows
+	}
+	return ctx.result
+}
+
+// resultErr returns an *Error from the errors in the context like err. If the
+// chain produced no error and no result, it returns an *Error wrapping
+// ErrNoResult.
+func (ctx *Context) resultErr(ok bool) error {
+	if !ok && len(ctx.errs) == 0 {
+		return newError(ctx.funct, ctx.source, ctx.Query, ctx.Args, ctx.start, ErrNoResult)
+	}
+	return ctx.err()
+}
+
+// err returns an *Error from the errors in the context or nil if empty.
+func (ctx *Context) err() error {
+	return newError(ctx.funct, ctx.source, ctx.Query, ctx.Args, ctx.start, ctx.errs...)
 }
@@ -0,0 +1,250 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAbort(t *testing.T) {
+	errHandler := errors.New("handler failed")
+
+	tests := []struct {
+		name  string
+		fn    Function
+		hndl  func(ctx context.Context, qctx *Context)
+		call  func(db *DB) error
+		err   error
+		calls []string
+	}{
+		{
+			name: "exec result",
+			fn:   FN_Exec,
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.SetResult(driver.RowsAffected(7))
+				qctx.Abort()
+			},
+			call: func(db *DB) error {
+				res, err := db.Exec("INSERT")
+				if err != nil {
+					return err
+				} else if n, _ := res.RowsAffected(); n != 7 {
+					return errors.New("unexpected result")
+				}
+				return nil
+			},
+		},
+		{
+			name: "exec without result",
+			fn:   FN_Exec,
+			call: func(db *DB) error {
+				res, err := db.Exec("INSERT")
+				if res != driver.ResultNoRows {
+					return errors.New("unexpected result")
+				}
+				return err
+			},
+		},
+		{
+			name: "exec error",
+			fn:   FN_Exec,
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Error(errHandler)
+				qctx.Abort()
+			},
+			call: func(db *DB) error {
+				res, err := db.Exec("INSERT")
+				if res != nil {
+					return errors.New("unexpected result")
+				}
+				return err
+			},
+			err: errHandler,
+		},
+		{
+			name: "query",
+			fn:   FN_Query,
+			call: func(db *DB) error {
+				_, err := db.Query("SELECT")
+				return err
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "begin",
+			fn:   FN_Begin,
+			call: func(db *DB) error {
+				_, err := db.Begin()
+				return err
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "prepare",
+			fn:   FN_Prepare,
+			call: func(db *DB) error {
+				_, err := db.Prepare("SELECT")
+				return err
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "ping",
+			fn:   FN_Ping,
+			call: func(db *DB) error {
+				return db.Ping()
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "close",
+			fn:   FN_Close,
+			call: func(db *DB) error {
+				return db.Close()
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "connection close",
+			fn:   FN_Close,
+			call: func(db *DB) error {
+				cn, err := db.Conn(context.Background())
+				if err != nil {
+					return err
+				}
+				defer cn.Connection().Close()
+				return cn.Close()
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "rows close",
+			fn:   FN_RowsClose,
+			call: func(db *DB) error {
+				rows, err := db.Query("SELECT")
+				if err != nil {
+					return err
+				}
+				defer rows.Rows().Close()
+				return rows.Close()
+			},
+			err:   ErrNoResult,
+			calls: []string{"query SELECT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.result("SELECT", []string{"id"})
+
+			hndl := tt.hndl
+			if hndl == nil {
+				hndl = func(ctx context.Context, qctx *Context) {
+					qctx.Abort()
+				}
+			}
+
+			resumed := false
+			db.Use(func(ctx context.Context, qctx *Context) {
+				qctx.Next()
+				resumed = qctx.IsAborted()
+			}, []Function{tt.fn})
+			db.Use(hndl, []Function{tt.fn})
+			db.Use(func(ctx context.Context, qctx *Context) {
+				t.Error("handler after the abort was called")
+			}, []Function{tt.fn})
+
+			err := tt.call(db)
+			if tt.err == nil && err != nil {
+				t.Fatal(err)
+			} else if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if serr := (*Error)(nil); tt.err != nil && !errors.As(err, &serr) {
+				t.Fatalf("got error %T, want *Error", err)
+			}
+			if !resumed {
+				t.Fatal("handler before the abort did not resume")
+			}
+			if calls := st.take(); !reflect.DeepEqual(calls, tt.calls) {
+				t.Fatalf("got calls %q, want %q", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestNewRows(t *testing.T) {
+	db, st := openFake(t)
+	st.result("MOCK", []string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+
+	db.Use(func(ctx context.Context, qctx *Context) {
+		rows, err := db.Database().QueryContext(ctx, "MOCK")
+		if err != nil {
+			qctx.Error(err)
+			return
+		}
+		qctx.SetRows(qctx.NewRows(rows))
+		qctx.Abort()
+	}, []Function{FN_Query})
+
+	nexts := 0
+	db.Use(func(ctx context.Context, qctx *Context) {
+		if qctx.Query != "SELECT" || qctx.Source() != SRC_Rows {
+			t.Errorf("got %s on %s, want SELECT on rows", qctx.Query, qctx.Source())
+		}
+		nexts++
+		qctx.Next()
+	}, []Function{FN_Next})
+
+	got, err := QueryAll[int64](context.Background(), db, "SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if nexts != 3 {
+		t.Fatalf("got %d handled calls of Next, want 3", nexts)
+	}
+	if calls, want := st.take(), []string{"query MOCK"}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("got calls %q, want %q", calls, want)
+	}
+}
+
+// expiredContext reports an error without a done channel, so only the fake
+// driver notices it.
+type expiredContext struct {
+	context.Context
+}
+
+func (expiredContext) Err() error {
+	return context.Canceled
+}
+
+func TestSetContext(t *testing.T) {
+	db, st := openFake(t)
+
+	var replaced context.Context
+	db.Use(func(ctx context.Context, qctx *Context) {
+		replaced = expiredContext{ctx}
+		qctx.SetContext(nil)
+		qctx.SetContext(replaced)
+		qctx.Next()
+	}, []Function{FN_Exec})
+	db.Use(func(ctx context.Context, qctx *Context) {
+		if ctx != replaced || qctx.Context() != replaced {
+			t.Error("later handler did not receive the replaced context")
+		}
+		qctx.Next()
+	}, []Function{FN_Exec})
+
+	if _, err := db.Exec("INSERT"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if calls := st.take(); len(calls) != 0 {
+		t.Fatalf("got calls %q, want none", calls)
+	}
+}
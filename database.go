@@ -7,17 +7,13 @@ import (
 	"time"
 )
 
-// hndl is an interface for getting middleware handlers for a sql function.
-type hndl interface {
-	fnHndl(fn Function) []handler
-}
-
 // DB is a wrapper class around sql.DB with middleware support. Middleware
 // handlers can be attached on different sql functions to extend their
 // features.
 type DB struct {
 	db   *sql.DB
-	mdws map[Function][]handler
+	mdws *scope
+	dial Dialect
 }
 
 // Database returns the underlying *sql.DB object.
@@ -25,8 +21,15 @@ func (db *DB) Database() *sql.DB {
 	return db.db
 }
 
+// Node returns the node identifying the database and its parents.
+func (db *DB) Node() *Node {
+	return db.mdws.node
+}
+
 // Open creates a new database *DB object from a driver and data source.
-// It calls sql.Open and stores a *sql.DB object internally
+// It calls sql.Open and stores a *sql.DB object internally. The dialect of the
+// database is selected by the driver name. Drivers wrapped with Register keep
+// their own chain, which runs in addition to the chain of the database.
 func Open(driverName string, dataSourceName string) (*DB, error) {
 	db, err := sql.Open(driverName, dataSourceName)
 	if err != nil {
@@ -35,32 +38,41 @@ func Open(driverName string, dataSourceName string) (*DB, error) {
 
 	return &DB{
 		db:   db,
-		mdws: map[Function][]handler{},
+		mdws: newScope(nil, SRC_Database),
+		dial: driverDialect(driverName),
 	}, nil
 }
 
 // OpenDB creates a new database *DB object from a driver.Connector.
-// It calls sql.OpenDB  and stores a *sql.DB object internally
+// It calls sql.OpenDB  and stores a *sql.DB object internally. The database
+// uses the standard dialect, which can be changed with SetDialect.
 func OpenDB(c driver.Connector) *DB {
 	return &DB{
 		db:   sql.OpenDB(c),
-		mdws: map[Function][]handler{},
+		mdws: newScope(nil, SRC_Database),
+		dial: StandardDialect{},
 	}
 }
 
-// Use attaches a middleware handler to specific sql functions. The function
-// panics if the handler is nil, or the list of functions is empty. Handlers
-// should be added in advance in a setup phase. The function is not thread safe.
-func (db *DB) Use(mdw func(context.Context, *Context), fns []Function) {
-	if len(fns) == 0 {
-		panic("function list is empty")
-	} else if mdw == nil {
-		panic("middleware is nil")
-	}
+// Use attaches a middleware handler to specific sql functions. Options can
+// name the handler, set its priority or ordering constraints on the chain, or
+// attach a matcher that selects which calls it runs for. The function panics
+// if the handler is nil, the list of functions is empty without a matcher, the
+// name is already used or the ordering constraints contain a cycle. The
+// returned handle can be used to remove the handler. The function is thread
+// safe and handlers can be added or removed while calls are in progress.
+func (db *DB) Use(
+	mdw func(context.Context, *Context),
+	fns []Function,
+	opts ...UseOption,
+) *Middleware {
+	return db.mdws.use(mdw, fns, opts)
+}
 
-	for _, fn := range fns {
-		db.mdws[fn] = append(db.mdws[fn], mdw)
-	}
+// Middlewares returns the middleware handlers that run for a sql function
+// called on the database, in the order they run.
+func (db *DB) Middlewares(fn Function) []*Middleware {
+	return db.mdws.middlewares(fn)
 }
 
 // Begin calls BeginTx with context.Background and no options.
@@ -72,44 +84,80 @@ func (db *DB) Begin() (*Tx, error) {
 // sql.BeginTx and stores a *sql.Tx object internally. The transaction
 // inherits the middlewares of the database object.
 func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
-	mdws := db.mdws[FN_Begin]
+	mdws := db.mdws.fnHndl(FN_Begin)
 	if len(mdws) == 0 {
+		start := time.Now()
 		if sqltx, err := db.db.BeginTx(ctx, opts); err != nil {
-			return nil, err
+			return nil, newError(FN_Begin, SRC_Database, "", nil, start, err)
 		} else {
-			return &Tx{sqltx, db, false}, nil
+			return newTx(sqltx, newScope(db.mdws, SRC_Transaction), db), nil
 		}
 	}
 
-	var tx *Tx
 	var err error
-	qctx := newContext(ctx, FN_Begin, SRC_Database, "", nil, mdws)
+	qctx := newContext(ctx, FN_Begin, SRC_Database, "", nil, db.mdws, mdws)
 	qctx.fn = func() {
-		if t, e := db.db.BeginTx(ctx, opts); e != nil {
+		if t, e := db.db.BeginTx(qctx.ctx, opts); e != nil {
 			qctx.Error(e)
 		} else {
-			tx = &Tx{t, db, false}
+			qctx.tx = newTx(t, newScope(db.mdws, SRC_Transaction), db)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return tx, err
+	err = qctx.resultErr(qctx.tx != nil)
+	return qctx.tx, err
+}
+
+// WithTx begins a transaction with options on the database and runs fn with it.
+// The transaction is committed if fn returns nil, and rolled back if fn returns
+// an error or panics, in which case the panic is re-raised after the rollback.
+// Beginning, committing and rolling back run their middleware handlers. If the
+// handlers of FN_Commit do not complete the transaction, it is rolled back.
+// Errors of the transaction's hooks are not returned, and are reported by
+// HookErrors of the transaction passed to fn.
+func (db *DB) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) error {
+	_, err := withTx(ctx, db, opts, fn)
+	return err
 }
 
-// Close closes the database connection. It calls sql.Close.
+// Close closes the database and prevents new queries from starting. It calls
+// sql.Close.
 func (db *DB) Close() error {
-	return db.db.Close()
+	mdws := db.mdws.fnHndl(FN_Close)
+	if len(mdws) == 0 {
+		start := time.Now()
+		return newError(FN_Close, SRC_Database, "", nil, start, db.db.Close())
+	}
+
+	var err error
+	var done bool
+	ctx := context.Background()
+	qctx := newContext(ctx, FN_Close, SRC_Database, "", nil, db.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := db.db.Close(); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	err = qctx.resultErr(done)
+	return err
 }
 
 // Conn returns a single connection *Conn object. It calls sql.Conn. The
-// connection inherits the middlewares of the database object.
+// connection inherits the middlewares of the database object. Errors are
+// returned in an *Error with FN_Connect as the function, but no handlers run.
 func (db *DB) Conn(ctx context.Context) (*Conn, error) {
+	start := time.Now()
 	conn, err := db.db.Conn(ctx)
 	if err != nil {
-		return nil, err
+		return nil, newError(FN_Connect, SRC_Database, "", nil, start, err)
 	}
-	return &Conn{conn, db}, nil
+	sc := newScope(db.mdws, SRC_Connection)
+	sc.sess = newSession()
+	return &Conn{conn, sc, db}, nil
 }
 
 // Driver returns the database's underlying driver. It calls sql.Driver.
@@ -123,27 +171,33 @@ func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
 }
 
 // ExecContext executes a query without returning any rows The args are for any
-// placeholder parameters in the query. It calls sql.ExecContext.
+// placeholder parameters in the query. It calls sql.ExecContext. If the context
+// carries a transaction created from the database, the query joins it.
 func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
-	mdws := db.mdws[FN_Exec]
+	if tx := db.ambientTx(ctx); tx != nil {
+		return tx.execContext(ctx, query, args, true)
+	}
+
+	mdws := db.mdws.fnHndl(FN_Exec)
 	if len(mdws) == 0 {
-		return db.db.ExecContext(ctx, query, args...)
+		start := time.Now()
+		res, err := db.db.ExecContext(ctx, query, args...)
+		return res, newError(FN_Exec, SRC_Database, query, args, start, err)
 	}
 
-	var res sql.Result
 	var err error
-	qctx := newContext(ctx, FN_Exec, SRC_Database, query, args, mdws)
+	qctx := newContext(ctx, FN_Exec, SRC_Database, query, args, db.mdws, mdws)
 	qctx.fn = func() {
-		if r, e := db.db.ExecContext(ctx, qctx.Query, qctx.Args...); e != nil {
+		if r, e := db.db.ExecContext(qctx.ctx, qctx.Query, qctx.Args...); e != nil {
 			qctx.Error(e)
 		} else {
-			res = r
+			qctx.result = r
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return res, err
+	err = qctx.err()
+	return qctx.execResult(), err
 }
 
 // Ping calls PingContext with context.Background.
@@ -154,21 +208,24 @@ func (db *DB) Ping() error {
 // PingContext verifies a connection to the database is still alive,
 // establishing a connection if necessary. It calls sql.PingContext.
 func (db *DB) PingContext(ctx context.Context) error {
-	mdws := db.mdws[FN_Ping]
+	mdws := db.mdws.fnHndl(FN_Ping)
 	if len(mdws) == 0 {
-		return db.db.PingContext(ctx)
+		start := time.Now()
+		return newError(FN_Ping, SRC_Database, "", nil, start, db.db.PingContext(ctx))
 	}
 
 	var err error
-	qctx := newContext(ctx, FN_Ping, SRC_Database, "", nil, mdws)
+	var done bool
+	qctx := newContext(ctx, FN_Ping, SRC_Database, "", nil, db.mdws, mdws)
 	qctx.fn = func() {
-		if e := db.db.PingContext(ctx); e != nil {
+		done = true
+		if e := db.db.PingContext(qctx.ctx); e != nil {
 			qctx.Error(e)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
+	err = qctx.resultErr(done)
 	return err
 }
 
@@ -179,61 +236,99 @@ func (db *DB) Prepare(query string) (*Stmt, error) {
 
 // PrepareContext creates a prepared statement for later queries or executions.
 // It calls sql.PrepareContext and stores a *sql.Stmt object internally. The
-// statement inherits the middlewares of the database object.
+// statement inherits the middlewares of the database object. If the context
+// carries a transaction created from the database, the statement is prepared
+// on it.
 func (db *DB) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
-	mdws := db.mdws[FN_Prepare]
+	if tx := db.ambientTx(ctx); tx != nil {
+		return tx.prepareContext(ctx, query, true)
+	}
+
+	mdws := db.mdws.fnHndl(FN_Prepare)
 	if len(mdws) == 0 {
+		start := time.Now()
 		if sqlstmt, err := db.db.PrepareContext(ctx, query); err != nil {
-			return nil, err
+			return nil, newError(FN_Prepare, SRC_Database, query, nil, start, err)
 		} else {
-			return &Stmt{sqlstmt, db, query}, nil
+			return &Stmt{sqlstmt, newScope(db.mdws, SRC_Statement), query}, nil
 		}
 	}
 
-	var stmt *Stmt
 	var err error
-	qctx := newContext(ctx, FN_Prepare, SRC_Database, query, nil, mdws)
+	qctx := newContext(ctx, FN_Prepare, SRC_Database, query, nil, db.mdws, mdws)
 	qctx.fn = func() {
-		if s, e := db.db.PrepareContext(ctx, qctx.Query); e != nil {
+		if s, e := db.db.PrepareContext(qctx.ctx, qctx.Query); e != nil {
 			qctx.Error(e)
 		} else {
-			stmt = &Stmt{s, db, query}
+			qctx.stmt = &Stmt{s, newScope(db.mdws, SRC_Statement), query}
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return stmt, err
+	err = qctx.resultErr(qctx.stmt != nil)
+	return qctx.stmt, err
 }
 
 // Query calls QueryContext with context.Background, query and args.
-func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+func (db *DB) Query(query string, args ...any) (*Rows, error) {
 	return db.QueryContext(context.Background(), query, args...)
 }
 
 // QueryContext executes a query that returns rows, typically a SELECT.
 // The args are for any placeholder parameters in the query. It calls
-// sql.QueryContext
-func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
-	mdws := db.mdws[FN_Query]
+// sql.QueryContext. If the context carries a transaction created from the
+// database, the query joins it.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	if tx := db.ambientTx(ctx); tx != nil {
+		return tx.queryContext(ctx, query, args, true)
+	}
+
+	mdws := db.mdws.fnHndl(FN_Query)
 	if len(mdws) == 0 {
-		return db.db.QueryContext(ctx, query, args...)
+		start := time.Now()
+		if sqlrows, err := db.db.QueryContext(ctx, query, args...); err != nil {
+			return nil, newError(FN_Query, SRC_Database, query, args, start, err)
+		} else {
+			return newRows(ctx, sqlrows, newScope(db.mdws, SRC_Rows), query), nil
+		}
 	}
 
-	var rows *sql.Rows
 	var err error
-	qctx := newContext(ctx, FN_Query, SRC_Database, query, args, mdws)
+	qctx := newContext(ctx, FN_Query, SRC_Database, query, args, db.mdws, mdws)
 	qctx.fn = func() {
-		if r, e := db.db.QueryContext(ctx, qctx.Query, qctx.Args...); e != nil {
+		if r, e := db.db.QueryContext(qctx.ctx, qctx.Query, qctx.Args...); e != nil {
 			qctx.Error(e)
 		} else {
-			rows = r
+			qctx.rows = newRows(qctx.ctx, r, newScope(db.mdws, SRC_Rows), qctx.Query)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return rows, err
+	err = qctx.resultErr(qctx.rows != nil)
+	return qctx.rows, err
+}
+
+// QueryRow calls QueryRowContext with context.Background, query and args.
+func (db *DB) QueryRow(query string, args ...any) *Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext executes a query that is expected to return a single row.
+// It calls QueryContext, so it runs the handlers of FN_Query. Errors are
+// deferred until the row's Scan method is called.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	return newRow(db.QueryContext(ctx, query, args...))
+}
+
+// Dialect returns the dialect of the database.
+func (db *DB) Dialect() Dialect {
+	return db.dial
+}
+
+// SetDialect sets the dialect of the database. Dialects should be set in
+// advance in a setup phase. The function is not thread safe.
+func (db *DB) SetDialect(d Dialect) {
+	db.dial = d
 }
 
 // SetConnMaxIdleTime sets the maximum amount of time a connection may be idle.
@@ -265,7 +360,11 @@ func (db *DB) Stats(n int) sql.DBStats {
 	return db.db.Stats()
 }
 
-// fnHndl returns middleware handlers for a sql function
-func (db *DB) fnHndl(fn Function) []handler {
-	return db.mdws[fn]
+// ambientTx returns the transaction stored in the context.Context if it was
+// created from the database object.
+func (db *DB) ambientTx(ctx context.Context) *Tx {
+	if tx := TxFromContext(ctx); tx != nil && tx.db == db {
+		return tx
+	}
+	return nil
 }
@@ -0,0 +1,99 @@
+package sqlm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAmbientTx(t *testing.T) {
+	calls := map[string]func(ctx context.Context, db *DB) error{
+		"exec": func(ctx context.Context, db *DB) error {
+			_, err := db.ExecContext(ctx, "INSERT")
+			return err
+		},
+		"query": func(ctx context.Context, db *DB) error {
+			rows, err := db.QueryContext(ctx, "SELECT")
+			if err == nil {
+				rows.Close()
+			}
+			return err
+		},
+		"prepare": func(ctx context.Context, db *DB) error {
+			stmt, err := db.PrepareContext(ctx, "SELECT")
+			if err == nil {
+				stmt.Close()
+			}
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.result("SELECT", []string{"id"})
+
+			other, err := Open("sqlmfake", t.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer other.Close()
+
+			type seen struct {
+				src     Source
+				ambient bool
+			}
+			got := []seen{}
+			db.Use(func(ctx context.Context, qctx *Context) {
+				got = append(got, seen{qctx.Source(), qctx.Ambient()})
+				qctx.Next()
+			}, []Function{FN_Exec, FN_Query, FN_Prepare})
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Rollback()
+			otx, err := other.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer otx.Rollback()
+
+			ctx := ContextWithTx(context.Background(), tx)
+			if TxFromContext(ctx) != tx {
+				t.Fatal("transaction not stored in the context")
+			}
+			if TxFromContext(context.Background()) != nil {
+				t.Fatal("got a transaction from an empty context")
+			}
+
+			if err := call(ctx, db); err != nil {
+				t.Fatal(err)
+			}
+			if err := call(ContextWithTx(context.Background(), otx), db); err != nil {
+				t.Fatal(err)
+			}
+			if err := call(context.Background(), db); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []seen{
+				{SRC_Transaction, true},
+				{SRC_Database, false},
+				{SRC_Database, false},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got calls %v, want %v", got, want)
+			}
+
+			got = got[:0]
+			if _, err := tx.ExecContext(ctx, "INSERT"); err != nil {
+				t.Fatal(err)
+			}
+			if want := []seen{{SRC_Transaction, false}}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got direct calls %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package sqlm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Dialect generates the database specific sql statements used by the library,
+// such as those managing savepoints.
+type Dialect interface {
+	// Savepoint returns the statement creating a savepoint.
+	Savepoint(name string) string
+	// ReleaseSavepoint returns the statement releasing a savepoint. If the
+	// database does not support releasing savepoints, it returns an empty
+	// string and nothing is executed.
+	ReleaseSavepoint(name string) string
+	// RollbackToSavepoint returns the statement rolling back to a savepoint.
+	RollbackToSavepoint(name string) string
+}
+
+// StandardDialect is the dialect of databases following the SQL standard for
+// savepoints, such as PostgreSQL, MySQL and SQLite.
+type StandardDialect struct{}
+
+func (StandardDialect) Savepoint(name string) string {
+	return "SAVEPOINT " + name
+}
+
+func (StandardDialect) ReleaseSavepoint(name string) string {
+	return "RELEASE SAVEPOINT " + name
+}
+
+func (StandardDialect) RollbackToSavepoint(name string) string {
+	return "ROLLBACK TO SAVEPOINT " + name
+}
+
+// SQLServerDialect is the dialect of Microsoft SQL Server.
+type SQLServerDialect struct{}
+
+func (SQLServerDialect) Savepoint(name string) string {
+	return "SAVE TRANSACTION " + name
+}
+
+func (SQLServerDialect) ReleaseSavepoint(name string) string {
+	return ""
+}
+
+func (SQLServerDialect) RollbackToSavepoint(name string) string {
+	return "ROLLBACK TRANSACTION " + name
+}
+
+// driverDialect returns the dialect of a driver by its registered name. Drivers
+// wrapped with Register use the dialect of the underlying driver.
+func driverDialect(driverName string) Dialect {
+	switch strings.TrimPrefix(driverName, DriverPrefix) {
+	case "sqlserver", "mssql", "azuresql":
+		return SQLServerDialect{}
+	default:
+		return StandardDialect{}
+	}
+}
+
+// identRegex matches identifiers that are safe to use in sql statements
+// without quoting.
+var identRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkIdent returns an error if a name is not a safe identifier.
+func checkIdent(name string) error {
+	if !identRegex.MatchString(name) {
+		return fmt.Errorf("sqlm: invalid identifier %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,814 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+// DriverPrefix is prepended to the name of drivers wrapped with Register.
+const DriverPrefix = "sqlm:"
+
+// Driver is a wrapper class around driver.Driver with middleware support.
+// Plain *sql.DB objects opened on the driver run its middleware handlers on
+// every call, so the queries of any consumer of the pool are observed. The
+// handlers run with SRC_Driver as the source, and the arguments of the query
+// context hold driver values. Handlers that abort a driver level call can only
+// supply a result for FN_Exec, and other aborted calls without an error fail
+// with ErrNoResult. Rows are not observed beyond FN_Query. The driver has its
+// own chain, separate from the chain of any *DB object: handlers attached with
+// DB.Use do not run at the driver level, and handlers attached to both run
+// twice for calls made through a *DB opened on the driver.
+type Driver struct {
+	drv     driver.Driver
+	mdws    *scope
+	onConn  []ConnHook
+	onReset []ConnHook
+}
+
+// ConnHook is a function called with a physical connection of a driver, such
+// as one initializing the session of new connections.
+type ConnHook func(ctx context.Context, conn driver.Conn) error
+
+// DriverOption configures a driver when it is wrapped.
+type DriverOption func(*Driver)
+
+// OnConnect adds a hook called on every new physical connection of the driver
+// before it is handed to the pool, such as one setting the time zone or the
+// application name of the session. The hooks run in the order they were added
+// within the FN_Connect chain. If a hook fails, the connection is closed and
+// the error is returned.
+func OnConnect(hook ConnHook) DriverOption {
+	return func(d *Driver) {
+		d.onConn = append(d.onConn, hook)
+	}
+}
+
+// OnConnReset adds a hook called every time a physical connection of the
+// driver is reset before it is reused from the pool, after the driver resets
+// the session. The hooks run in the order they were added within the
+// FN_ResetSession chain. If a hook or a handler of FN_ResetSession fails, the
+// connection is discarded.
+func OnConnReset(hook ConnHook) DriverOption {
+	return func(d *Driver) {
+		d.onReset = append(d.onReset, hook)
+	}
+}
+
+// WrapDriver creates a new driver *Driver object from a driver.Driver with
+// options.
+func WrapDriver(drv driver.Driver, opts ...DriverOption) *Driver {
+	d := &Driver{
+		drv:  drv,
+		mdws: newScope(nil, SRC_Driver),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Register wraps a driver and registers the wrapper with sql.Register under
+// the driver name prefixed with DriverPrefix, such as "sqlm:postgres". The
+// wrapper can then be opened with sql.Open or Open. Like sql.Register, it
+// panics if the name is already registered.
+func Register(driverName string, drv driver.Driver, opts ...DriverOption) *Driver {
+	d := WrapDriver(drv, opts...)
+	sql.Register(DriverPrefix+driverName, d)
+	return d
+}
+
+// Use attaches a middleware handler to specific sql functions of the driver.
+// It accepts the same options and panics in the same cases as DB.Use. The
+// function is thread safe.
+func (d *Driver) Use(
+	mdw func(context.Context, *Context),
+	fns []Function,
+	opts ...UseOption,
+) *Middleware {
+	return d.mdws.use(mdw, fns, opts)
+}
+
+// Middlewares returns the middleware handlers that run for a sql function
+// called on the driver, in the order they run.
+func (d *Driver) Middlewares(fn Function) []*Middleware {
+	return d.mdws.middlewares(fn)
+}
+
+// Driver returns the underlying driver.Driver object.
+func (d *Driver) Driver() driver.Driver {
+	return d.drv
+}
+
+// Open returns a new connection to the database. It opens the connection with
+// the Open method of the underlying driver, so no connector is created that
+// would have to be closed, and runs the connect hooks on it.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c := &Connector{dsnConnector{name, d.drv}, d}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector creates a connector for a data source. The connections of
+// the connector run the middleware handlers of the driver.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.drv.(driver.DriverContext); ok {
+		c, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &Connector{c, d}, nil
+	}
+	return &Connector{dsnConnector{name, d.drv}, d}, nil
+}
+
+// Connector is a wrapper class around driver.Connector with middleware
+// support. It can be passed to sql.OpenDB or OpenDB, and its connections run
+// the middleware handlers of its driver.
+type Connector struct {
+	conn driver.Connector
+	drv  *Driver
+}
+
+// WrapConnector creates a new connector *Connector object from a
+// driver.Connector with options. The connector has its own driver with an
+// empty chain, separate from the chain of a *DB opened on the connector.
+func WrapConnector(c driver.Connector, opts ...DriverOption) *Connector {
+	return &Connector{c, WrapDriver(c.Driver(), opts...)}
+}
+
+// Use attaches a middleware handler to specific sql functions of the
+// connector's driver. See Driver.Use for details.
+func (c *Connector) Use(
+	mdw func(context.Context, *Context),
+	fns []Function,
+	opts ...UseOption,
+) *Middleware {
+	return c.drv.Use(mdw, fns, opts...)
+}
+
+// Middlewares returns the middleware handlers that run for a sql function
+// called on the connector's driver, in the order they run.
+func (c *Connector) Middlewares(fn Function) []*Middleware {
+	return c.drv.Middlewares(fn)
+}
+
+// Connector returns the underlying driver.Connector object.
+func (c *Connector) Connector() driver.Connector {
+	return c.conn
+}
+
+// Connect returns a new connection to the database and runs the connect hooks
+// of the driver on it. Each connection has its own session store shared by
+// every call made on it.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	sc := newScope(c.drv.mdws, SRC_Connection)
+	sc.sess = newSession()
+
+	mdws := sc.fnHndl(FN_Connect)
+	if len(mdws) == 0 {
+		if cn, err := c.connect(ctx); err != nil {
+			return nil, err
+		} else {
+			return &driverConn{cn, sc, c.drv}, nil
+		}
+	}
+
+	qctx := newContext(ctx, FN_Connect, SRC_Driver, "", nil, sc, mdws)
+	qctx.fn = func() {
+		if cn, e := c.connect(qctx.ctx); e != nil {
+			qctx.Error(e)
+		} else {
+			qctx.drv = &driverConn{cn, sc, c.drv}
+		}
+	}
+
+	qctx.Next()
+	if err := qctx.cause(); err != nil {
+		return nil, err
+	} else if cn, ok := qctx.drv.(driver.Conn); ok {
+		return cn, nil
+	}
+	return nil, ErrNoResult
+}
+
+// connect opens a connection on the underlying connector and runs the connect
+// hooks on it. The connection is closed if a hook fails.
+func (c *Connector) connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.conn.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range c.drv.onConn {
+		if err := hook(ctx, cn); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// Driver returns the driver of the connector.
+func (c *Connector) Driver() driver.Driver {
+	return c.drv
+}
+
+// Close closes the underlying connector if it implements io.Closer. It is
+// called by sql.Close.
+func (c *Connector) Close() error {
+	if cl, ok := c.conn.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+// dsnConnector is a connector for drivers that do not implement
+// driver.DriverContext.
+type dsnConnector struct {
+	name string
+	drv  driver.Driver
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.drv.Open(c.name)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+// driverConn wraps a connection of a driver and runs the middleware handlers
+// on its calls.
+type driverConn struct {
+	cn   driver.Conn
+	mdws *scope
+	drv  *Driver
+}
+
+// Prepare calls PrepareContext with context.Background and query.
+func (c *driverConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares a statement on the connection.
+func (c *driverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	mdws := c.mdws.fnHndl(FN_Prepare)
+	if len(mdws) == 0 {
+		if s, err := c.prepare(ctx, query); err != nil {
+			return nil, err
+		} else {
+			return &driverStmt{s, c, query}, nil
+		}
+	}
+
+	qctx := newContext(ctx, FN_Prepare, SRC_Driver, query, nil, c.mdws, mdws)
+	qctx.fn = func() {
+		if s, e := c.prepare(qctx.ctx, qctx.Query); e != nil {
+			qctx.Error(e)
+		} else {
+			qctx.drv = &driverStmt{s, c, qctx.Query}
+		}
+	}
+
+	qctx.Next()
+	if err := qctx.cause(); err != nil {
+		return nil, err
+	} else if s, ok := qctx.drv.(driver.Stmt); ok {
+		return s, nil
+	}
+	return nil, ErrNoResult
+}
+
+// prepare prepares a statement on the underlying connection.
+func (c *driverConn) prepare(ctx context.Context, query string) (driver.Stmt, error) {
+	if pc, ok := c.cn.(driver.ConnPrepareContext); ok {
+		return pc.PrepareContext(ctx, query)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.cn.Prepare(query)
+}
+
+// Close closes the connection.
+func (c *driverConn) Close() error {
+	mdws := c.mdws.fnHndl(FN_Close)
+	if len(mdws) == 0 {
+		return c.cn.Close()
+	}
+
+	var done bool
+	ctx := context.Background()
+	qctx := newContext(ctx, FN_Close, SRC_Driver, "", nil, c.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := c.cn.Close(); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	return qctx.resultCause(done)
+}
+
+// Begin calls BeginTx with context.Background and default options.
+func (c *driverConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction on the connection.
+func (c *driverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	mdws := c.mdws.fnHndl(FN_Begin)
+	if len(mdws) == 0 {
+		if t, err := c.beginTx(ctx, opts); err != nil {
+			return nil, err
+		} else {
+			return &driverTx{t, c, ctx}, nil
+		}
+	}
+
+	qctx := newContext(ctx, FN_Begin, SRC_Driver, "", nil, c.mdws, mdws)
+	qctx.fn = func() {
+		if t, e := c.beginTx(qctx.ctx, opts); e != nil {
+			qctx.Error(e)
+		} else {
+			qctx.drv = &driverTx{t, c, qctx.ctx}
+		}
+	}
+
+	qctx.Next()
+	if err := qctx.cause(); err != nil {
+		return nil, err
+	} else if t, ok := qctx.drv.(driver.Tx); ok {
+		return t, nil
+	}
+	return nil, ErrNoResult
+}
+
+// beginTx starts a transaction on the underlying connection.
+func (c *driverConn) beginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if bc, ok := c.cn.(driver.ConnBeginTx); ok {
+		return bc.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("sqlm: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("sqlm: driver does not support read-only transactions")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.cn.Begin()
+}
+
+// ExecContext executes a query without returning any rows. It returns
+// driver.ErrSkip if the underlying connection implements neither
+// driver.ExecerContext nor driver.Execer, in which case the query is prepared
+// and executed as a statement.
+func (c *driverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	exec := c.execer()
+	if exec == nil {
+		return nil, driver.ErrSkip
+	}
+
+	mdws := c.mdws.fnHndl(FN_Exec)
+	if len(mdws) == 0 {
+		return exec(ctx, query, args)
+	}
+
+	qctx := newContext(ctx, FN_Exec, SRC_Driver, query, contextArgs(args), c.mdws, mdws)
+	qctx.fn = func() {
+		if r, e := exec(qctx.ctx, qctx.Query, namedValues(qctx.Args)); e != nil {
+			qctx.Error(e)
+		} else {
+			qctx.result = r
+		}
+	}
+
+	qctx.Next()
+	if err := qctx.cause(); err != nil {
+		return nil, err
+	} else if qctx.result != nil {
+		return qctx.result, nil
+	}
+	return driver.ResultNoRows, nil
+}
+
+// QueryContext executes a query that returns rows. It returns driver.ErrSkip
+// if the underlying connection implements neither driver.QueryerContext nor
+// driver.Queryer, in which case the query is prepared and executed as a
+// statement.
+func (c *driverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryRows := c.queryer()
+	if queryRows == nil {
+		return nil, driver.ErrSkip
+	}
+
+	mdws := c.mdws.fnHndl(FN_Query)
+	if len(mdws) == 0 {
+		return queryRows(ctx, query, args)
+	}
+
+	qctx := newContext(ctx, FN_Query, SRC_Driver, query, contextArgs(args), c.mdws, mdws)
+	qctx.fn = func() {
+		if r, e := queryRows(qctx.ctx, qctx.Query, namedValues(qctx.Args)); e != nil {
+			qctx.Error(e)
+		} else {
+			qctx.drv = r
+		}
+	}
+
+	qctx.Next()
+	if err := qctx.cause(); err != nil {
+		return nil, err
+	} else if r, ok := qctx.drv.(driver.Rows); ok {
+		return r, nil
+	}
+	return nil, ErrNoResult
+}
+
+// execer returns a function executing queries on the underlying connection,
+// or nil if it does not support executing queries without preparing them.
+func (c *driverConn) execer() func(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if ec, ok := c.cn.(driver.ExecerContext); ok {
+		return ec.ExecContext
+	}
+	ex, ok := c.cn.(driver.Execer)
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+		vals, err := driverValues(args)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return ex.Exec(query, vals)
+	}
+}
+
+// queryer returns a function querying rows on the underlying connection, or
+// nil if it does not support querying without preparing the query.
+func (c *driverConn) queryer() func(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	if qc, ok := c.cn.(driver.QueryerContext); ok {
+		return qc.QueryContext
+	}
+	qr, ok := c.cn.(driver.Queryer)
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+		vals, err := driverValues(args)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return qr.Query(query, vals)
+	}
+}
+
+// Ping verifies the connection is still alive.
+func (c *driverConn) Ping(ctx context.Context) error {
+	pn, ok := c.cn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+
+	mdws := c.mdws.fnHndl(FN_Ping)
+	if len(mdws) == 0 {
+		return pn.Ping(ctx)
+	}
+
+	var done bool
+	qctx := newContext(ctx, FN_Ping, SRC_Driver, "", nil, c.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := pn.Ping(qctx.ctx); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	return qctx.resultCause(done)
+}
+
+// ResetSession resets the session of the connection before it is reused and
+// runs the reset hooks of the driver on it. Every error of the FN_ResetSession
+// chain, including ErrNoResult if it was aborted, is marked with
+// driver.ErrBadConn so the connection is discarded.
+func (c *driverConn) ResetSession(ctx context.Context) error {
+	mdws := c.mdws.fnHndl(FN_ResetSession)
+	if len(mdws) == 0 {
+		return badConn(c.resetSession(ctx))
+	}
+
+	var done bool
+	qctx := newContext(ctx, FN_ResetSession, SRC_Driver, "", nil, c.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := c.resetSession(qctx.ctx); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	return badConn(qctx.resultCause(done))
+}
+
+// resetSession resets the session of the underlying connection and runs the
+// reset hooks on it.
+func (c *driverConn) resetSession(ctx context.Context) error {
+	if sr, ok := c.cn.(driver.SessionResetter); ok {
+		if err := sr.ResetSession(ctx); err != nil {
+			return err
+		}
+	}
+	for _, hook := range c.drv.onReset {
+		if err := hook(ctx, c.cn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// badConn marks an error with driver.ErrBadConn unless it already is one.
+func badConn(err error) error {
+	if err == nil || errors.Is(err, driver.ErrBadConn) {
+		return err
+	}
+	return badConnError{err}
+}
+
+// badConnError marks an error with driver.ErrBadConn, so database/sql discards
+// the connection it occurred on.
+type badConnError struct {
+	err error
+}
+
+func (e badConnError) Error() string {
+	return e.err.Error()
+}
+
+func (e badConnError) Unwrap() []error {
+	return []error{e.err, driver.ErrBadConn}
+}
+
+// IsValid reports whether the connection can be reused.
+func (c *driverConn) IsValid() bool {
+	if v, ok := c.cn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue converts the arguments of a query to driver values. It
+// returns driver.ErrSkip if the underlying connection does not implement
+// driver.NamedValueChecker, in which case the default conversion is used.
+func (c *driverConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.cn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// driverTx wraps a transaction of a driver and runs the middleware handlers
+// on its calls with the context.Context the transaction was started with.
+type driverTx struct {
+	tx  driver.Tx
+	cn  *driverConn
+	ctx context.Context
+}
+
+// Commit commits the transaction.
+func (t *driverTx) Commit() error {
+	mdws := t.cn.mdws.fnHndl(FN_Commit)
+	if len(mdws) == 0 {
+		return t.tx.Commit()
+	}
+
+	var done bool
+	qctx := newContext(t.ctx, FN_Commit, SRC_Driver, "", nil, t.cn.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := t.tx.Commit(); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	return qctx.resultCause(done)
+}
+
+// Rollback aborts the transaction.
+func (t *driverTx) Rollback() error {
+	mdws := t.cn.mdws.fnHndl(FN_Rollback)
+	if len(mdws) == 0 {
+		return t.tx.Rollback()
+	}
+
+	var done bool
+	qctx := newContext(t.ctx, FN_Rollback, SRC_Driver, "", nil, t.cn.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := t.tx.Rollback(); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	return qctx.resultCause(done)
+}
+
+// driverStmt wraps a prepared statement of a driver and runs the middleware
+// handlers on its calls.
+type driverStmt struct {
+	st    driver.Stmt
+	cn    *driverConn
+	query string
+}
+
+// Close closes the statement.
+func (s *driverStmt) Close() error {
+	return s.st.Close()
+}
+
+// NumInput returns the number of placeholder parameters.
+func (s *driverStmt) NumInput() int {
+	return s.st.NumInput()
+}
+
+// Exec executes the statement without returning any rows.
+func (s *driverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.st.Exec(args)
+}
+
+// Query executes the statement that returns rows.
+func (s *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.st.Query(args)
+}
+
+// CheckNamedValue converts an argument of the statement to a driver value. It
+// calls the checker of the underlying statement, or the checker of the
+// connection if the statement does not implement driver.NamedValueChecker.
+func (s *driverStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.st.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return s.cn.CheckNamedValue(nv)
+}
+
+// ColumnConverter returns the converter of an argument of the statement. It
+// returns driver.DefaultParameterConverter if the underlying statement does
+// not implement driver.ColumnConverter.
+func (s *driverStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if cc, ok := s.st.(driver.ColumnConverter); ok {
+		return cc.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+// ExecContext executes the statement without returning any rows.
+func (s *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	mdws := s.cn.mdws.fnHndl(FN_Exec)
+	if len(mdws) == 0 {
+		return s.exec(ctx, args)
+	}
+
+	qctx := newContext(ctx, FN_Exec, SRC_Driver, s.query, contextArgs(args), s.cn.mdws, mdws)
+	qctx.fn = func() {
+		if r, e := s.exec(qctx.ctx, namedValues(qctx.Args)); e != nil {
+			qctx.Error(e)
+		} else {
+			qctx.result = r
+		}
+	}
+
+	qctx.Next()
+	if err := qctx.cause(); err != nil {
+		return nil, err
+	} else if qctx.result != nil {
+		return qctx.result, nil
+	}
+	return driver.ResultNoRows, nil
+}
+
+// exec executes the underlying statement.
+func (s *driverStmt) exec(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if ec, ok := s.st.(driver.StmtExecContext); ok {
+		return ec.ExecContext(ctx, args)
+	}
+	vals, err := driverValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.st.Exec(vals)
+}
+
+// QueryContext executes the statement that returns rows.
+func (s *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	mdws := s.cn.mdws.fnHndl(FN_Query)
+	if len(mdws) == 0 {
+		return s.queryRows(ctx, args)
+	}
+
+	qctx := newContext(ctx, FN_Query, SRC_Driver, s.query, contextArgs(args), s.cn.mdws, mdws)
+	qctx.fn = func() {
+		if r, e := s.queryRows(qctx.ctx, namedValues(qctx.Args)); e != nil {
+			qctx.Error(e)
+		} else {
+			qctx.drv = r
+		}
+	}
+
+	qctx.Next()
+	if err := qctx.cause(); err != nil {
+		return nil, err
+	} else if r, ok := qctx.drv.(driver.Rows); ok {
+		return r, nil
+	}
+	return nil, ErrNoResult
+}
+
+// queryRows queries the underlying statement.
+func (s *driverStmt) queryRows(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if qc, ok := s.st.(driver.StmtQueryContext); ok {
+		return qc.QueryContext(ctx, args)
+	}
+	vals, err := driverValues(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.st.Query(vals)
+}
+
+// contextArgs converts the named values of a driver call to the arguments of
+// a query context. Named values are converted to sql.NamedArg.
+func contextArgs(nvs []driver.NamedValue) []any {
+	args := make([]any, len(nvs))
+	for i, nv := range nvs {
+		if nv.Name != "" {
+			args[i] = sql.Named(nv.Name, nv.Value)
+		} else {
+			args[i] = nv.Value
+		}
+	}
+	return args
+}
+
+// namedValues converts the arguments of a query context back to the named
+// values of a driver call.
+func namedValues(args []any) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		if na, ok := arg.(sql.NamedArg); ok {
+			nvs[i] = driver.NamedValue{Name: na.Name, Ordinal: i + 1, Value: na.Value}
+		} else {
+			nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+		}
+	}
+	return nvs
+}
+
+// driverValues converts named values to values for drivers that do not
+// support named parameters.
+func driverValues(nvs []driver.NamedValue) ([]driver.Value, error) {
+	vals := make([]driver.Value, len(nvs))
+	for i, nv := range nvs {
+		if nv.Name != "" {
+			return nil, errors.New("sqlm: driver does not support the use of named parameters")
+		}
+		vals[i] = nv.Value
+	}
+	return vals, nil
+}
+
+var (
+	_ driver.Driver             = (*Driver)(nil)
+	_ driver.DriverContext      = (*Driver)(nil)
+	_ driver.Connector          = (*Connector)(nil)
+	_ io.Closer                 = (*Connector)(nil)
+	_ driver.ConnPrepareContext = (*driverConn)(nil)
+	_ driver.ConnBeginTx        = (*driverConn)(nil)
+	_ driver.ExecerContext      = (*driverConn)(nil)
+	_ driver.QueryerContext     = (*driverConn)(nil)
+	_ driver.Pinger             = (*driverConn)(nil)
+	_ driver.SessionResetter    = (*driverConn)(nil)
+	_ driver.Validator          = (*driverConn)(nil)
+	_ driver.NamedValueChecker  = (*driverConn)(nil)
+	_ driver.StmtExecContext    = (*driverStmt)(nil)
+	_ driver.StmtQueryContext   = (*driverStmt)(nil)
+	_ driver.NamedValueChecker  = (*driverStmt)(nil)
+	_ driver.ColumnConverter    = (*driverStmt)(nil)
+)
@@ -0,0 +1,559 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakeWrapped is the fake driver wrapped and registered as "sqlm:sqlmfake".
+var fakeWrapped = Register("sqlmfake", fakeDriver{})
+
+// openWrapped opens a *sql.DB on the wrapped fake driver with a data source
+// unique to the test.
+func openWrapped(t *testing.T) (*sql.DB, *fakeState) {
+	t.Helper()
+	st := &fakeState{results: map[string]fakeResult{}}
+	fakeStates.Store(t.Name(), st)
+
+	db, err := sql.Open(DriverPrefix+"sqlmfake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeStates.Delete(t.Name())
+	})
+	return db, st
+}
+
+// useWrapped attaches a handler to the wrapped fake driver until the test
+// ends.
+func useWrapped(t *testing.T, mdw func(context.Context, *Context), fns []Function) {
+	m := fakeWrapped.Use(mdw, fns)
+	t.Cleanup(m.Remove)
+}
+
+// fakeConnector connects to a data source of the fake driver.
+type fakeConnector struct {
+	dsn    string
+	legacy bool
+	closed bool
+}
+
+func (c *fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := fakeDriver{}.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	} else if c.legacy {
+		return legacyConn{cn.(*fakeConn)}, nil
+	}
+	return cn, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+func (c *fakeConnector) Close() error {
+	c.closed = true
+	return nil
+}
+
+// legacyConn is a connection implementing only the deprecated driver.Execer
+// and driver.Queryer interfaces to execute queries without preparing them.
+type legacyConn struct {
+	cn *fakeConn
+}
+
+func (c legacyConn) Prepare(query string) (driver.Stmt, error) {
+	return c.cn.Prepare(query)
+}
+
+func (c legacyConn) Close() error {
+	return c.cn.Close()
+}
+
+func (c legacyConn) Begin() (driver.Tx, error) {
+	return c.cn.Begin()
+}
+
+func (c legacyConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.cn.ExecContext(context.Background(), query, fakeNamedValues(args))
+}
+
+func (c legacyConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.cn.QueryContext(context.Background(), query, fakeNamedValues(args))
+}
+
+func fakeNamedValues(args []driver.Value) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return nvs
+}
+
+func TestDriverHandlers(t *testing.T) {
+	db, st := openWrapped(t)
+	st.result("SELECT", []string{"id"}, []driver.Value{int64(1)})
+
+	got := []string{}
+	useWrapped(t, func(ctx context.Context, qctx *Context) {
+		if qctx.Source() != SRC_Driver {
+			t.Errorf("got source %s, want driver", qctx.Source())
+		}
+		got = append(got, qctx.Function().String()+" "+qctx.Query)
+		qctx.Next()
+	}, []Function{FN_Exec, FN_Query, FN_Prepare, FN_Begin, FN_Commit, FN_Rollback})
+
+	var args []any
+	useWrapped(t, func(ctx context.Context, qctx *Context) {
+		args = qctx.Args
+		qctx.Next()
+	}, []Function{FN_Exec})
+
+	res, err := db.Exec("INSERT", 1, sql.Named("name", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("got %d arguments at the driver, want 2", n)
+	}
+	if want := []any{int64(1), sql.Named("name", "a")}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("got args %v, want %v", args, want)
+	}
+
+	var id int64
+	if err := db.QueryRow("SELECT").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := db.Prepare("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stmt.QueryRow().Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+
+	for _, commit := range []bool{true, false} {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"exec INSERT",
+		"query SELECT",
+		"prepare SELECT",
+		"exec SELECT",
+		"query SELECT",
+		"begin ",
+		"commit ",
+		"begin ",
+		"rollback ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestDriverAbort(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   Function
+		call func(db *sql.DB) error
+		err  error
+	}{
+		{
+			name: "exec",
+			fn:   FN_Exec,
+			call: func(db *sql.DB) error {
+				res, err := db.Exec("INSERT")
+				if err != nil {
+					return err
+				} else if _, err := res.RowsAffected(); err == nil {
+					return errors.New("got rows affected")
+				}
+				return nil
+			},
+		},
+		{
+			name: "query",
+			fn:   FN_Query,
+			call: func(db *sql.DB) error {
+				_, err := db.Query("SELECT")
+				return err
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "prepare",
+			fn:   FN_Prepare,
+			call: func(db *sql.DB) error {
+				_, err := db.Prepare("SELECT")
+				return err
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "begin",
+			fn:   FN_Begin,
+			call: func(db *sql.DB) error {
+				_, err := db.Begin()
+				return err
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "commit",
+			fn:   FN_Commit,
+			call: func(db *sql.DB) error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				return tx.Commit()
+			},
+			err: ErrNoResult,
+		},
+		{
+			name: "rollback",
+			fn:   FN_Rollback,
+			call: func(db *sql.DB) error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				return tx.Rollback()
+			},
+			err: ErrNoResult,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openWrapped(t)
+			st.result("SELECT", []string{"id"})
+
+			useWrapped(t, func(ctx context.Context, qctx *Context) {
+				qctx.Abort()
+			}, []Function{tt.fn})
+
+			err := tt.call(db)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			for _, call := range st.take() {
+				if call != "begin" {
+					t.Fatalf("got call %q of an aborted function", call)
+				}
+			}
+		})
+	}
+}
+
+func TestDriverOpen(t *testing.T) {
+	st := &fakeState{results: map[string]fakeResult{}}
+	fakeStates.Store(t.Name(), st)
+	defer fakeStates.Delete(t.Name())
+
+	cn, err := fakeWrapped.Open(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+	if _, ok := cn.(*driverConn); !ok {
+		t.Fatalf("got connection %T, want a wrapped connection", cn)
+	}
+	if fakeWrapped.Driver() != (fakeDriver{}) {
+		t.Fatal("got a different underlying driver")
+	}
+}
+
+func TestConnector(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		st := &fakeState{results: map[string]fakeResult{}}
+		st.result("SELECT", []string{"id"}, []driver.Value{int64(1)})
+		fakeStates.Store(t.Name(), st)
+
+		fc := &fakeConnector{dsn: t.Name(), legacy: legacy}
+		c := WrapConnector(fc)
+		if c.Connector() != fc {
+			t.Fatal("got a different underlying connector")
+		}
+
+		got := []Function{}
+		c.Use(func(ctx context.Context, qctx *Context) {
+			got = append(got, qctx.Function())
+			qctx.Next()
+		}, []Function{FN_Exec, FN_Query})
+
+		db := sql.OpenDB(c)
+		if _, err := db.Exec("INSERT"); err != nil {
+			t.Fatal(err)
+		}
+		var id int64
+		if err := db.QueryRow("SELECT").Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+
+		// Queries are not prepared, even on connections only implementing
+		// the deprecated interfaces.
+		want := []string{"exec INSERT", "query SELECT"}
+		if calls := st.take(); !reflect.DeepEqual(calls, want) {
+			t.Fatalf("legacy %t: got calls %q, want %q", legacy, calls, want)
+		}
+		if !reflect.DeepEqual(got, []Function{FN_Exec, FN_Query}) {
+			t.Fatalf("legacy %t: got functions %v", legacy, got)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !fc.closed {
+			t.Fatalf("legacy %t: underlying connector was not closed", legacy)
+		}
+		fakeStates.Delete(t.Name())
+	}
+}
+
+// convStmt is a statement with its own argument conversion.
+type convStmt struct {
+	*fakeStmt
+}
+
+func (s convStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	nv.Value = "checked"
+	return nil
+}
+
+func (s convStmt) ColumnConverter(idx int) driver.ValueConverter {
+	return driver.Bool
+}
+
+func TestDriverStmtConverters(t *testing.T) {
+	cn := &driverConn{cn: &fakeConn{}}
+
+	s := &driverStmt{st: convStmt{}, cn: cn}
+	nv := driver.NamedValue{Ordinal: 1, Value: 1}
+	if err := s.CheckNamedValue(&nv); err != nil || nv.Value != "checked" {
+		t.Fatalf("got %v and error %v, want the statement's checker", nv.Value, err)
+	}
+	if v, err := s.ColumnConverter(0).ConvertValue("true"); err != nil || v != true {
+		t.Fatalf("got %v and error %v, want the statement's converter", v, err)
+	}
+
+	s = &driverStmt{st: &fakeStmt{}, cn: cn}
+	if err := s.CheckNamedValue(&nv); err != driver.ErrSkip {
+		t.Fatalf("got error %v, want %v", err, driver.ErrSkip)
+	}
+	if cc := s.ColumnConverter(0); cc != driver.DefaultParameterConverter {
+		t.Fatalf("got converter %T, want the default", cc)
+	}
+}
+
+func TestContextArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		nvs  []driver.NamedValue
+		args []any
+	}{
+		{name: "empty", nvs: []driver.NamedValue{}, args: []any{}},
+		{
+			name: "positional",
+			nvs:  []driver.NamedValue{{Ordinal: 1, Value: int64(1)}, {Ordinal: 2, Value: "a"}},
+			args: []any{int64(1), "a"},
+		},
+		{
+			name: "named",
+			nvs:  []driver.NamedValue{{Ordinal: 1, Value: int64(1)}, {Name: "b", Ordinal: 2, Value: nil}},
+			args: []any{int64(1), sql.Named("b", nil)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := contextArgs(tt.nvs)
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("got args %v, want %v", args, tt.args)
+			}
+			if nvs := namedValues(args); !reflect.DeepEqual(nvs, tt.nvs) {
+				t.Fatalf("got named values %v, want %v", nvs, tt.nvs)
+			}
+		})
+	}
+
+	if _, err := driverValues([]driver.NamedValue{{Name: "a", Value: 1}}); err == nil {
+		t.Fatal("converted named values for a driver without named parameters")
+	}
+}
+
+func TestConnHooks(t *testing.T) {
+	st := &fakeState{results: map[string]fakeResult{}}
+	fakeStates.Store(t.Name(), st)
+	defer fakeStates.Delete(t.Name())
+
+	got := []string{}
+	hook := func(name string) ConnHook {
+		return func(ctx context.Context, conn driver.Conn) error {
+			if _, ok := conn.(*fakeConn); !ok {
+				t.Errorf("got connection %T, want the underlying connection", conn)
+			}
+			got = append(got, name)
+			return nil
+		}
+	}
+
+	c := WrapConnector(
+		&fakeConnector{dsn: t.Name()},
+		OnConnect(hook("connect 1")),
+		OnConnReset(hook("reset 1")),
+		OnConnect(hook("connect 2")),
+		OnConnReset(hook("reset 2")),
+	)
+	c.Use(func(ctx context.Context, qctx *Context) {
+		got = append(got, qctx.Function().String()+" start")
+		qctx.Next()
+		got = append(got, qctx.Function().String()+" end")
+	}, []Function{FN_Connect, FN_ResetSession})
+
+	db := sql.OpenDB(c)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec("INSERT"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"connect start",
+		"connect 1",
+		"connect 2",
+		"connect end",
+		"reset session start",
+		"reset 1",
+		"reset 2",
+		"reset session end",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestConnHookErrors(t *testing.T) {
+	errHook := errors.New("hook failed")
+	failHook := func(ctx context.Context, conn driver.Conn) error {
+		return errHook
+	}
+
+	tests := []struct {
+		name   string
+		opts   []DriverOption
+		hndl   func(ctx context.Context, qctx *Context)
+		err    error
+		conns  int
+		closed int
+	}{
+		{
+			name:   "connect hook",
+			opts:   []DriverOption{OnConnect(failHook)},
+			err:    errHook,
+			conns:  1,
+			closed: 1,
+		},
+		{
+			name: "connect hook with handler",
+			opts: []DriverOption{OnConnect(failHook)},
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Next()
+			},
+			err:    errHook,
+			conns:  1,
+			closed: 1,
+		},
+		{
+			name:   "reset hook",
+			opts:   []DriverOption{OnConnReset(failHook)},
+			conns:  2,
+			closed: 1,
+		},
+		{
+			name: "reset handler",
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Next()
+				if qctx.Function() == FN_ResetSession {
+					qctx.Error(errHook)
+				}
+			},
+			conns:  2,
+			closed: 1,
+		},
+		{
+			name: "reset abort",
+			hndl: func(ctx context.Context, qctx *Context) {
+				if qctx.Function() == FN_ResetSession {
+					qctx.Abort()
+				} else {
+					qctx.Next()
+				}
+			},
+			conns:  2,
+			closed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &fakeState{results: map[string]fakeResult{}}
+			fakeStates.Store(t.Name(), st)
+			defer fakeStates.Delete(t.Name())
+
+			c := WrapConnector(&fakeConnector{dsn: t.Name()}, tt.opts...)
+			if tt.hndl != nil {
+				c.Use(tt.hndl, []Function{FN_Connect, FN_ResetSession})
+			}
+
+			db := sql.OpenDB(c)
+			db.SetMaxOpenConns(1)
+
+			// The first call opens a connection and the second one resets it
+			// before reusing it, or opens a new one if the reset failed.
+			var err error
+			for i := 0; i < 2 && err == nil; i++ {
+				_, err = db.Exec("INSERT")
+			}
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if conns, closed := st.counts(); conns != tt.conns || closed != tt.closed {
+				t.Fatalf("got %d connections and %d closed, want %d and %d",
+					conns, closed, tt.conns, tt.closed)
+			}
+			db.Close()
+			if conns, closed := st.counts(); closed != conns {
+				t.Fatalf("got %d of %d connections closed", closed, conns)
+			}
+		})
+	}
+}
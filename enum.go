@@ -1,5 +1,9 @@
 package sqlm
 
+import (
+	"fmt"
+)
+
 type Function int
 
 const (
@@ -10,8 +14,46 @@ const (
 	FN_Ping
 	FN_Prepare
 	FN_Query
+	FN_Next
+	FN_Scan
+	FN_NextResultSet
+	FN_RowsClose
+	FN_Close
+	FN_Savepoint
+	FN_Release
+	FN_RollbackTo
+	FN_Connect
+	FN_ResetSession
 )
 
+var functionNames = map[Function]string{
+	FN_Begin:         "begin",
+	FN_Commit:        "commit",
+	FN_Rollback:      "rollback",
+	FN_Exec:          "exec",
+	FN_Ping:          "ping",
+	FN_Prepare:       "prepare",
+	FN_Query:         "query",
+	FN_Next:          "next",
+	FN_Scan:          "scan",
+	FN_NextResultSet: "next result set",
+	FN_RowsClose:     "rows close",
+	FN_Close:         "close",
+	FN_Savepoint:     "savepoint",
+	FN_Release:       "release",
+	FN_RollbackTo:    "rollback to",
+	FN_Connect:       "connect",
+	FN_ResetSession:  "reset session",
+}
+
+// String returns the name of the sql function.
+func (fn Function) String() string {
+	if name, ok := functionNames[fn]; ok {
+		return name
+	}
+	return fmt.Sprintf("Function(%d)", int(fn))
+}
+
 type Source int
 
 const (
@@ -19,4 +61,53 @@ const (
 	SRC_Transaction
 	SRC_Statement
 	SRC_Connection
+	SRC_Rows
+	SRC_Driver
+)
+
+var sourceNames = map[Source]string{
+	SRC_Database:    "database",
+	SRC_Transaction: "transaction",
+	SRC_Statement:   "statement",
+	SRC_Connection:  "connection",
+	SRC_Rows:        "rows",
+	SRC_Driver:      "driver",
+}
+
+// String returns the name of the sql object.
+func (src Source) String() string {
+	if name, ok := sourceNames[src]; ok {
+		return name
+	}
+	return fmt.Sprintf("Source(%d)", int(src))
+}
+
+type Kind int
+
+const (
+	KIND_Unknown Kind = iota
+	KIND_Read
+	KIND_Write
+	KIND_Schema
+)
+
+type TxState int
+
+const (
+	TX_Active TxState = iota
+	TX_Committed
+	TX_RolledBack
+	TX_Failed
+)
+
+type ErrorClass int
+
+const (
+	ERR_Unknown ErrorClass = iota
+	ERR_UniqueViolation
+	ERR_ForeignKeyViolation
+	ERR_NotNullViolation
+	ERR_Deadlock
+	ERR_SerializationFailure
+	ERR_Connection
 )
@@ -0,0 +1,63 @@
+package sqlm
+
+import (
+	"fmt"
+	"time"
+)
+
+// Error is returned by sql functions when the underlying call or a middleware
+// handler failed. It wraps the first error as the cause, along with any other
+// errors added to the query context, and describes the failed operation. Some
+// errors are returned unwrapped: sql.ErrNoRows and ErrTooManyRows of Row.Scan
+// and QueryOne, the errors of Columns, ColumnTypes and the mapping errors of
+// the scanning helpers, and the errors of the driver level wrapper, which are
+// seen by database/sql.
+type Error struct {
+	Function Function
+	Source   Source
+	Query    string
+	NumArgs  int
+	Duration time.Duration
+	Err      error
+	Errs     []error
+}
+
+// newError creates an *Error from the errors of an operation that started at
+// some time. It returns nil if there are no errors.
+func newError(
+	funct Function,
+	source Source,
+	query string,
+	args []any,
+	start time.Time,
+	errs ...error,
+) error {
+	if len(errs) == 0 || errs[0] == nil {
+		return nil
+	}
+	return &Error{
+		Function: funct,
+		Source:   source,
+		Query:    query,
+		NumArgs:  len(args),
+		Duration: time.Since(start),
+		Err:      errs[0],
+		Errs:     errs[1:],
+	}
+}
+
+// Error returns the message of the cause with the failed operation.
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("sqlm: %s on %s failed: %v", e.Function, e.Source, e.Err)
+	if len(e.Errs) != 0 {
+		msg += fmt.Sprintf(" (and %d more errors)", len(e.Errs))
+	}
+	return msg
+}
+
+// Unwrap returns the cause followed by the other errors of the operation.
+func (e *Error) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errs)+1)
+	errs = append(errs, e.Err)
+	return append(errs, e.Errs...)
+}
@@ -0,0 +1,172 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestError(t *testing.T) {
+	errCause := errors.New("cause")
+	errOther := errors.New("other")
+
+	if err := newError(FN_Exec, SRC_Database, "", nil, time.Now()); err != nil {
+		t.Fatalf("got %v without errors", err)
+	}
+	if err := newError(FN_Exec, SRC_Database, "", nil, time.Now(), nil); err != nil {
+		t.Fatalf("got %v with a nil cause", err)
+	}
+
+	tests := []struct {
+		errs []error
+		msg  string
+	}{
+		{
+			errs: []error{errCause},
+			msg:  "sqlm: exec on database failed: cause",
+		},
+		{
+			errs: []error{errCause, errOther, errOther},
+			msg:  "sqlm: exec on database failed: cause (and 2 more errors)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			err := newError(FN_Exec, SRC_Database, "INSERT", []any{1, 2}, time.Now(), tt.errs...)
+			if msg := err.Error(); msg != tt.msg {
+				t.Fatalf("got message %q, want %q", msg, tt.msg)
+			}
+
+			var serr *Error
+			if !errors.As(err, &serr) {
+				t.Fatalf("got error %T, want *Error", err)
+			}
+			if serr.Query != "INSERT" || serr.NumArgs != 2 || serr.Err != errCause {
+				t.Fatalf("got %+v", serr)
+			}
+			for _, e := range tt.errs {
+				if !errors.Is(err, e) {
+					t.Fatalf("error does not wrap %v", e)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorWrapping(t *testing.T) {
+	errIter := errors.New("iteration failed")
+
+	tests := []struct {
+		name string
+		call func(db *DB) error
+		fn   Function
+		err  error
+	}{
+		{
+			name: "commit after rollback",
+			call: func(db *DB) error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				tx.Rollback()
+				return tx.Commit()
+			},
+			fn:  FN_Commit,
+			err: sql.ErrTxDone,
+		},
+		{
+			name: "release after rollback",
+			call: func(db *DB) error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback()
+				sp, err := tx.Begin()
+				if err != nil {
+					return err
+				}
+				sp.Rollback()
+				return sp.Commit()
+			},
+			fn:  FN_Release,
+			err: sql.ErrTxDone,
+		},
+		{
+			name: "nested transaction options",
+			call: func(db *DB) error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback()
+				_, err = tx.BeginTx(context.Background(), &sql.TxOptions{})
+				return err
+			},
+			fn: FN_Savepoint,
+		},
+		{
+			name: "invalid savepoint name",
+			call: func(db *DB) error {
+				tx, err := db.Begin()
+				if err != nil {
+					return err
+				}
+				defer tx.Rollback()
+				_, err = tx.Savepoint(context.Background(), "sp; DROP TABLE t")
+				return err
+			},
+			fn: FN_Savepoint,
+		},
+		{
+			name: "connection",
+			call: func(db *DB) error {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := db.Conn(ctx)
+				return err
+			},
+			fn:  FN_Connect,
+			err: context.Canceled,
+		},
+		{
+			name: "rows iteration",
+			call: func(db *DB) error {
+				rows, err := db.Query("SELECT")
+				if err != nil {
+					return err
+				}
+				defer rows.Close()
+				for rows.Next() {
+				}
+				return rows.Err()
+			},
+			fn:  FN_Next,
+			err: errIter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.result("SELECT", []string{"id"}, []driver.Value{int64(1)}, []driver.Value{errIter})
+
+			err := tt.call(db)
+			var serr *Error
+			if !errors.As(err, &serr) {
+				t.Fatalf("got error %v, want *Error", err)
+			}
+			if serr.Function != tt.fn {
+				t.Fatalf("got function %s, want %s", serr.Function, tt.fn)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeState records the calls made on the fake driver for one data source and
+// holds the results of its queries.
+type fakeState struct {
+	mtx       sync.Mutex
+	calls     []string
+	results   map[string]fakeResult
+	commitErr error
+	conns     int
+	closed    int
+}
+
+// fakeResult is the result of a query on the fake driver.
+type fakeResult struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+// fakeStates holds the state of each data source of the fake driver.
+var fakeStates sync.Map
+
+func init() {
+	sql.Register("sqlmfake", fakeDriver{})
+}
+
+// openFake opens a database on the fake driver with a data source unique to
+// the test.
+func openFake(t *testing.T) (*DB, *fakeState) {
+	t.Helper()
+	st := &fakeState{results: map[string]fakeResult{}}
+	fakeStates.Store(t.Name(), st)
+
+	db, err := Open("sqlmfake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeStates.Delete(t.Name())
+	})
+	return db, st
+}
+
+// result sets the result of a query.
+func (st *fakeState) result(query string, cols []string, rows ...[]driver.Value) {
+	st.mtx.Lock()
+	st.results[query] = fakeResult{cols, rows}
+	st.mtx.Unlock()
+}
+
+// record appends a call to the calls of the data source.
+func (st *fakeState) record(call string) {
+	st.mtx.Lock()
+	st.calls = append(st.calls, call)
+	st.mtx.Unlock()
+}
+
+// take returns and clears the calls of the data source.
+func (st *fakeState) take() []string {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	calls := st.calls
+	st.calls = nil
+	return calls
+}
+
+// counts returns the number of connections opened and closed on the data
+// source.
+func (st *fakeState) counts() (conns, closed int) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.conns, st.closed
+}
+
+// sqlStateError is a driver error exposing a SQLSTATE code.
+type sqlStateError string
+
+func (e sqlStateError) Error() string {
+	return "fake: sqlstate " + string(e)
+}
+
+func (e sqlStateError) SQLState() string {
+	return string(e)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	st, ok := fakeStates.Load(dsn)
+	if !ok {
+		return nil, errors.New("fake: unknown data source " + dsn)
+	}
+	fs := st.(*fakeState)
+	fs.mtx.Lock()
+	fs.conns++
+	fs.mtx.Unlock()
+	return &fakeConn{fs}, nil
+}
+
+type fakeConn struct {
+	st *fakeState
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(query, "FAIL") {
+		return nil, errors.New("fake: " + query)
+	}
+	c.st.record("prepare " + query)
+	return &fakeStmt{c, query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	c.st.mtx.Lock()
+	c.st.closed++
+	c.st.mtx.Unlock()
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.st.record("begin")
+	return &fakeTx{c}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	} else if strings.HasPrefix(query, "FAIL") {
+		return nil, errors.New("fake: " + query)
+	}
+	c.st.record("exec " + query)
+	return driver.RowsAffected(len(args)), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.st.mtx.Lock()
+	res, ok := c.st.results[query]
+	c.st.mtx.Unlock()
+	if !ok {
+		return nil, errors.New("fake: " + query)
+	}
+	c.st.record("query " + query)
+	return &fakeRows{res: res}, nil
+}
+
+type fakeTx struct {
+	cn *fakeConn
+}
+
+func (t *fakeTx) Commit() error {
+	t.cn.st.record("commit")
+	t.cn.st.mtx.Lock()
+	defer t.cn.st.mtx.Unlock()
+	return t.cn.st.commitErr
+}
+
+func (t *fakeTx) Rollback() error {
+	t.cn.st.record("rollback")
+	return nil
+}
+
+type fakeStmt struct {
+	cn    *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("fake: not implemented")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("fake: not implemented")
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.cn.ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.cn.QueryContext(ctx, s.query, args)
+}
+
+type fakeRows struct {
+	res fakeResult
+	idx int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.res.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+// Next copies the next row into dest. Rows holding an error fail with it.
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.idx >= len(r.res.rows) {
+		return io.EOF
+	}
+	for _, v := range r.res.rows[r.idx] {
+		if err, ok := v.(error); ok {
+			return err
+		}
+	}
+	copy(dest, r.res.rows[r.idx])
+	r.idx++
+	return nil
+}
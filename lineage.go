@@ -0,0 +1,43 @@
+package sqlm
+
+import (
+	"sync/atomic"
+)
+
+// nodeSeq generates the IDs of sql objects.
+var nodeSeq atomic.Uint64
+
+// Node identifies a sql object and links it to the object it was created from.
+// Statements link to the transaction or connection they were prepared on,
+// transactions to the connection or database they were begun on, and so on up
+// to the database object.
+type Node struct {
+	ID     uint64
+	Source Source
+	Parent *Node
+}
+
+// newNode creates a new node with a unique ID.
+func newNode(src Source, parent *Node) *Node {
+	return &Node{
+		ID:     nodeSeq.Add(1),
+		Source: src,
+		Parent: parent,
+	}
+}
+
+// Lineage returns the chain of nodes from the database object down to the
+// node.
+func (n *Node) Lineage() []*Node {
+	depth := 0
+	for p := n; p != nil; p = p.Parent {
+		depth++
+	}
+
+	nodes := make([]*Node, depth)
+	for p := n; p != nil; p = p.Parent {
+		depth--
+		nodes[depth] = p
+	}
+	return nodes
+}
@@ -0,0 +1,196 @@
+package sqlm
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Matcher decides whether a middleware handler runs for a call. Matchers are
+// evaluated once per call by the library before the middleware chain starts,
+// and only see the values of the query context at that time.
+type Matcher func(*Context) bool
+
+// When attaches a matcher to the middleware handler. The handler only runs for
+// calls that the matcher accepts. If a matcher is attached, the list of
+// functions in Use may be empty, in which case the handler is attached to all
+// sql functions.
+func When(m Matcher) UseOption {
+	return func(mdw *Middleware) {
+		mdw.match = m
+	}
+}
+
+// MatchFunction matches calls of any of the listed sql functions.
+func MatchFunction(fns ...Function) Matcher {
+	return func(ctx *Context) bool {
+		for _, fn := range fns {
+			if ctx.funct == fn {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchSource matches calls initiated by any of the listed sql objects.
+func MatchSource(srcs ...Source) Matcher {
+	return func(ctx *Context) bool {
+		for _, src := range srcs {
+			if ctx.source == src {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchKind matches calls whose query is any of the listed statement kinds.
+func MatchKind(kinds ...Kind) Matcher {
+	return func(ctx *Context) bool {
+		knd := ctx.Kind()
+		for _, k := range kinds {
+			if knd == k {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchQuery matches calls whose query matches a regular expression.
+func MatchQuery(re *regexp.Regexp) Matcher {
+	return func(ctx *Context) bool {
+		return re.MatchString(ctx.Query)
+	}
+}
+
+// MatchLabel matches calls whose context.Context carries a label with a value.
+// Labels are added to a context.Context with WithLabel.
+func MatchLabel(key string, val string) Matcher {
+	return func(ctx *Context) bool {
+		v, ok := Label(ctx.ctx, key)
+		return ok && v == val
+	}
+}
+
+// MatchAll matches calls that all of the matchers accept.
+func MatchAll(ms ...Matcher) Matcher {
+	return func(ctx *Context) bool {
+		for _, m := range ms {
+			if !m(ctx) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAny matches calls that any of the matchers accept.
+func MatchAny(ms ...Matcher) Matcher {
+	return func(ctx *Context) bool {
+		for _, m := range ms {
+			if m(ctx) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchNot matches calls that the matcher does not accept.
+func MatchNot(m Matcher) Matcher {
+	return func(ctx *Context) bool {
+		return !m(ctx)
+	}
+}
+
+// labelsKey is the context.Context key of the labels map.
+type labelsKey struct{}
+
+// WithLabel returns a copy of the context.Context with a label added to it.
+// Labels can be used to route middleware handlers with MatchLabel.
+func WithLabel(ctx context.Context, key string, val string) context.Context {
+	old, _ := ctx.Value(labelsKey{}).(map[string]string)
+	lbls := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		lbls[k] = v
+	}
+	lbls[key] = val
+	return context.WithValue(ctx, labelsKey{}, lbls)
+}
+
+// Label returns the value of a label in the context.Context.
+func Label(ctx context.Context, key string) (string, bool) {
+	lbls, _ := ctx.Value(labelsKey{}).(map[string]string)
+	val, ok := lbls[key]
+	return val, ok
+}
+
+// queryKind returns the statement kind of a query from its leading keyword.
+// Common table expressions are classified as writes if they contain any data
+// modifying keyword.
+func queryKind(query string) Kind {
+	words := keywords(query)
+	if len(words) == 0 {
+		return KIND_Unknown
+	}
+
+	switch words[0] {
+	case "SELECT", "SHOW", "EXPLAIN", "DESCRIBE", "DESC", "VALUES", "TABLE":
+		return KIND_Read
+	case "INSERT", "UPDATE", "DELETE", "MERGE", "UPSERT", "REPLACE", "COPY":
+		return KIND_Write
+	case "CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME", "COMMENT", "GRANT", "REVOKE":
+		return KIND_Schema
+	case "WITH":
+		for _, w := range words[1:] {
+			switch w {
+			case "INSERT", "UPDATE", "DELETE", "MERGE":
+				return KIND_Write
+			}
+		}
+		return KIND_Read
+	default:
+		return KIND_Unknown
+	}
+}
+
+// keywords splits a query into upper case words, skipping comments and
+// string literals.
+func keywords(query string) []string {
+	words := []string{}
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(query)
+			}
+		case c == '\'' || c == '"' || c == '`':
+			if j := strings.IndexByte(query[i+1:], c); j >= 0 {
+				i += j + 2
+			} else {
+				i = len(query)
+			}
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(query) && (query[j] == '_' || unicode.IsLetter(rune(query[j])) || unicode.IsDigit(rune(query[j]))) {
+				j++
+			}
+			words = append(words, strings.ToUpper(query[i:j]))
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
@@ -0,0 +1,105 @@
+package sqlm
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestKeywords(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "", want: []string{}},
+		{query: "select id from t", want: []string{"SELECT", "ID", "FROM", "T"}},
+		{query: "SELECT a_1, b2 FROM t1", want: []string{"SELECT", "A_1", "B2", "FROM", "T1"}},
+		{query: "-- DELETE\nSELECT 1", want: []string{"SELECT"}},
+		{query: "SELECT 1 -- DELETE", want: []string{"SELECT"}},
+		{query: "/* DELETE */ SELECT /* x */ 1", want: []string{"SELECT"}},
+		{query: "SELECT /* unterminated", want: []string{"SELECT"}},
+		{query: "SELECT 'DELETE', \"UPDATE\", `INSERT`", want: []string{"SELECT"}},
+		{query: "SELECT 'it''s' AS x", want: []string{"SELECT", "AS", "X"}},
+		{query: "SELECT 'unterminated", want: []string{"SELECT"}},
+		{query: "(SELECT 1) UNION (SELECT 2)", want: []string{"SELECT", "UNION", "SELECT"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := keywords(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryKind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Kind
+	}{
+		{query: "", want: KIND_Unknown},
+		{query: "-- only a comment", want: KIND_Unknown},
+		{query: "BEGIN", want: KIND_Unknown},
+		{query: "select * from t", want: KIND_Read},
+		{query: "  (SELECT 1)", want: KIND_Read},
+		{query: "EXPLAIN DELETE FROM t", want: KIND_Read},
+		{query: "SHOW TABLES", want: KIND_Read},
+		{query: "insert into t values (1)", want: KIND_Write},
+		{query: "/* hint */ UPDATE t SET a = 1", want: KIND_Write},
+		{query: "DELETE FROM t", want: KIND_Write},
+		{query: "CREATE TABLE t (id int)", want: KIND_Schema},
+		{query: "DROP TABLE t", want: KIND_Schema},
+		{query: "WITH x AS (SELECT 1) SELECT * FROM x", want: KIND_Read},
+		{query: "WITH x AS (DELETE FROM t RETURNING id) SELECT * FROM x", want: KIND_Write},
+		{query: "WITH x AS (SELECT 'DELETE') SELECT * FROM x", want: KIND_Read},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := queryKind(tt.query); got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	ctx := WithLabel(context.Background(), "tenant", "a")
+	ctx = WithLabel(ctx, "route", "api")
+	sc := newScope(nil, SRC_Database)
+	qctx := newContext(ctx, FN_Exec, SRC_Transaction, "UPDATE t SET a = 1", nil, sc, nil)
+
+	tests := []struct {
+		name  string
+		match Matcher
+		want  bool
+	}{
+		{name: "function", match: MatchFunction(FN_Query, FN_Exec), want: true},
+		{name: "other function", match: MatchFunction(FN_Query), want: false},
+		{name: "source", match: MatchSource(SRC_Transaction), want: true},
+		{name: "other source", match: MatchSource(SRC_Database), want: false},
+		{name: "kind", match: MatchKind(KIND_Write), want: true},
+		{name: "other kind", match: MatchKind(KIND_Read, KIND_Schema), want: false},
+		{name: "query", match: MatchQuery(regexp.MustCompile(`(?i)^update`)), want: true},
+		{name: "label", match: MatchLabel("tenant", "a"), want: true},
+		{name: "earlier label", match: MatchLabel("route", "api"), want: true},
+		{name: "label value", match: MatchLabel("tenant", "b"), want: false},
+		{name: "missing label", match: MatchLabel("user", ""), want: false},
+		{name: "all", match: MatchAll(MatchKind(KIND_Write), MatchSource(SRC_Transaction)), want: true},
+		{name: "not all", match: MatchAll(MatchKind(KIND_Write), MatchSource(SRC_Database)), want: false},
+		{name: "empty all", match: MatchAll(), want: true},
+		{name: "any", match: MatchAny(MatchKind(KIND_Read), MatchSource(SRC_Transaction)), want: true},
+		{name: "empty any", match: MatchAny(), want: false},
+		{name: "not", match: MatchNot(MatchKind(KIND_Read)), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.match(qctx); got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier is a sql object that can execute queries. It is implemented by DB,
+// Tx and Conn, so data access code written against it runs in or out of a
+// transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *Row
+}
+
+// Preparer is a sql object that can create prepared statements. It is
+// implemented by DB, Tx and Conn.
+type Preparer interface {
+	PrepareContext(ctx context.Context, query string) (*Stmt, error)
+}
+
+// Beginner is a sql object that can begin transactions. It is implemented by
+// DB and Conn, and by Tx which begins nested transactions on savepoints.
+type Beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error)
+}
+
+var (
+	_ Querier  = (*DB)(nil)
+	_ Querier  = (*Tx)(nil)
+	_ Querier  = (*Conn)(nil)
+	_ Preparer = (*DB)(nil)
+	_ Preparer = (*Tx)(nil)
+	_ Preparer = (*Conn)(nil)
+	_ Beginner = (*DB)(nil)
+	_ Beginner = (*Conn)(nil)
+	_ Beginner = (*Tx)(nil)
+)
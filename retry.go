@@ -0,0 +1,117 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"time"
+)
+
+// AttemptKey is the key of the attempt counter in the Values of query contexts
+// created during a retrying transaction. The counter starts from 1.
+const AttemptKey = "sqlm.attempt"
+
+// attemptKey is the context.Context key of the attempt counter.
+type attemptKey struct{}
+
+// Attempt returns the attempt counter of the retrying transaction running with
+// the context.Context, or 0 if there is none.
+func Attempt(ctx context.Context) int {
+	n, _ := ctx.Value(attemptKey{}).(int)
+	return n
+}
+
+// RetryPolicy configures how a retrying transaction is replayed. Zero values
+// are replaced with defaults.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the transaction runs. The
+	// default is 3.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry, which doubles with each
+	// retry. The default is 10ms.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between retries. The default is 1s.
+	MaxBackoff time.Duration
+	// Retryable decides whether an error causes the transaction to be retried.
+	// The default retries serialization failures and deadlocks.
+	Retryable func(err error) bool
+}
+
+// backoff returns the delay before a retry after an attempt. The delay is
+// randomized between half and the full exponential backoff.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if half := d / 2; half > 0 {
+		d = half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+	return d
+}
+
+// withDefaults returns the policy with zero values replaced with defaults.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = 10 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = time.Second
+	}
+	if p.Retryable == nil {
+		p.Retryable = isRetryable
+	}
+	return p
+}
+
+// isRetryable reports whether an error is a serialization failure or a
+// deadlock.
+func isRetryable(err error) bool {
+	switch Classify(err) {
+	case ERR_SerializationFailure, ERR_Deadlock:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryTx runs fn in a transaction like WithTx, and replays the whole
+// transaction from the start if it fails with an error the policy considers
+// retryable. Between attempts it waits with an exponential backoff with
+// jitter, and stops early if the context is done. The function receives a
+// context.Context carrying the attempt counter, which can be read with Attempt.
+// Query contexts created with it hold the counter in their Values under
+// AttemptKey. The error of the last attempt is returned. A transaction that
+// was committed is never replayed, even if a handler of FN_Commit added an
+// error after the commit.
+func (db *DB) RetryTx(
+	ctx context.Context,
+	opts *sql.TxOptions,
+	policy RetryPolicy,
+	fn func(ctx context.Context, tx *Tx) error,
+) error {
+	policy = policy.withDefaults()
+	for attempt := 1; ; attempt++ {
+		actx := context.WithValue(ctx, attemptKey{}, attempt)
+		committed, err := withTx(actx, db, opts, func(tx *Tx) error {
+			return fn(actx, tx)
+		})
+		if err == nil || committed || attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
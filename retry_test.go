@@ -0,0 +1,137 @@
+package sqlm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetryTx(t *testing.T) {
+	errSerialization := sqlStateError("40001")
+	errOther := errors.New("other failure")
+
+	tests := []struct {
+		name      string
+		commitErr error
+		afterErr  error
+		attempts  []int
+		err       error
+	}{
+		{
+			name:     "success",
+			attempts: []int{1},
+		},
+		{
+			name:      "replayed up to max attempts",
+			commitErr: errSerialization,
+			attempts:  []int{1, 2, 3},
+			err:       errSerialization,
+		},
+		{
+			name:      "not retryable",
+			commitErr: errOther,
+			attempts:  []int{1},
+			err:       errOther,
+		},
+		{
+			name:     "committed",
+			afterErr: errSerialization,
+			attempts: []int{1},
+			err:      errSerialization,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.commitErr = tt.commitErr
+
+			db.Use(func(ctx context.Context, qctx *Context) {
+				qctx.Next()
+				if tt.afterErr != nil {
+					qctx.Error(tt.afterErr)
+				}
+			}, []Function{FN_Commit})
+
+			values := []int{}
+			db.Use(func(ctx context.Context, qctx *Context) {
+				n, _ := qctx.Get(AttemptKey)
+				values = append(values, n.(int))
+				qctx.Next()
+			}, []Function{FN_Exec})
+
+			attempts := []int{}
+			policy := RetryPolicy{MinBackoff: time.Microsecond}
+			err := db.RetryTx(context.Background(), nil, policy, func(ctx context.Context, tx *Tx) error {
+				attempts = append(attempts, Attempt(ctx))
+				_, err := tx.ExecContext(ctx, "INSERT")
+				return err
+			})
+
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(attempts, tt.attempts) {
+				t.Fatalf("got attempts %v, want %v", attempts, tt.attempts)
+			}
+			if !reflect.DeepEqual(values, tt.attempts) {
+				t.Fatalf("got attempt values %v, want %v", values, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryTxDoneContext(t *testing.T) {
+	db, _ := openFake(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attempts := 0
+	policy := RetryPolicy{MinBackoff: time.Hour, MaxBackoff: time.Hour}
+	err := db.RetryTx(ctx, nil, policy, func(ctx context.Context, tx *Tx) error {
+		attempts++
+		cancel()
+		return sqlStateError("40P01")
+	})
+
+	if !IsDeadlock(err) {
+		t.Fatalf("got error %v, want deadlock", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("got %d attempts, want 1", attempts)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{}.withDefaults()
+	if p.MaxAttempts != 3 || p.MinBackoff != 10*time.Millisecond || p.MaxBackoff != time.Second {
+		t.Fatalf("got defaults %+v", p)
+	}
+	if !p.Retryable(sqlStateError("40001")) || !p.Retryable(sqlStateError("40P01")) {
+		t.Fatal("serialization failures and deadlocks are not retryable")
+	}
+	if p.Retryable(sqlStateError("23505")) || p.Retryable(errors.New("other")) {
+		t.Fatal("other errors are retryable")
+	}
+
+	p = RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 10 * time.Millisecond},
+		{attempt: 2, max: 20 * time.Millisecond},
+		{attempt: 4, max: 80 * time.Millisecond},
+		{attempt: 5, max: 100 * time.Millisecond},
+		{attempt: 1000, max: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Fatalf("attempt %d: got backoff %s, want between %s and %s", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+}
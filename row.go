@@ -0,0 +1,96 @@
+package sqlm
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrTooManyRows is returned by Row.Scan when the query returned more than
+// one row.
+var ErrTooManyRows = errors.New("sqlm: more than one row in result set")
+
+// Row is the result of calling QueryRow to select a single row. Unlike
+// sql.Row, it reports queries returning more than one row and exposes the
+// column metadata of the result.
+type Row struct {
+	rows  *Rows
+	err   error
+	cols  []string
+	types []*sql.ColumnType
+}
+
+// newRow creates a new *Row object from the result of a query. The column
+// metadata is read eagerly, and the rows are closed if it fails.
+func newRow(rows *Rows, err error) *Row {
+	if err != nil {
+		return &Row{err: err}
+	}
+
+	row := &Row{rows: rows}
+	if row.cols, row.err = rows.Columns(); row.err == nil {
+		row.types, row.err = rows.ColumnTypes()
+	}
+	if row.err != nil {
+		rows.Close()
+	}
+	return row
+}
+
+// Err returns the error, if any, that was encountered while running the query.
+func (r *Row) Err() error {
+	return r.err
+}
+
+// Columns returns the column names of the result.
+func (r *Row) Columns() []string {
+	return r.cols
+}
+
+// ColumnTypes returns column information such as column type, length, and
+// nullable of the result.
+func (r *Row) ColumnTypes() []*sql.ColumnType {
+	return r.types
+}
+
+// Scan copies the columns of the single row into the values pointed at by
+// dest and closes the rows. If the query selected no rows, it returns
+// sql.ErrNoRows. If the query selected more than one row, it returns
+// ErrTooManyRows, and dest holds the values of the first row.
+func (r *Row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	err := r.scan(dest)
+	if cerr := r.rows.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// scan copies the columns of the single row into dest, and checks that no
+// other rows follow.
+func (r *Row) scan(dest []any) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+	if r.rows.Next() {
+		return ErrTooManyRows
+	}
+	return r.rows.Err()
+}
+
+// Close closes the rows without scanning. It is only needed if Scan is not
+// called.
+func (r *Row) Close() error {
+	if r.rows == nil {
+		return nil
+	}
+	return r.rows.Close()
+}
@@ -0,0 +1,127 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRow(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		rows  [][]driver.Value
+		want  int64
+		err   error
+	}{
+		{
+			name:  "one row",
+			query: "SELECT",
+			rows:  [][]driver.Value{{int64(1), "a"}},
+			want:  1,
+		},
+		{
+			name:  "no rows",
+			query: "SELECT",
+			err:   sql.ErrNoRows,
+		},
+		{
+			name:  "many rows",
+			query: "SELECT",
+			rows:  [][]driver.Value{{int64(1), "a"}, {int64(2), "b"}},
+			want:  1,
+			err:   ErrTooManyRows,
+		},
+		{
+			name:  "query error",
+			query: "SELECT missing",
+		},
+	}
+
+	queries := map[string]func(db *DB, query string) (*Row, func()){
+		"database": func(db *DB, query string) (*Row, func()) {
+			return db.QueryRow(query), func() {}
+		},
+		"statement": func(db *DB, query string) (*Row, func()) {
+			stmt, err := db.Prepare(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return stmt.QueryRow(), func() { stmt.Close() }
+		},
+	}
+
+	for src, query := range queries {
+		for _, tt := range tests {
+			t.Run(src+" "+tt.name, func(t *testing.T) {
+				db, st := openFake(t)
+				st.result("SELECT", []string{"id", "name"}, tt.rows...)
+
+				row, done := query(db, tt.query)
+				defer done()
+
+				var id int64
+				var name string
+				err := row.Scan(&id, &name)
+
+				if tt.query != "SELECT" {
+					var serr *Error
+					if !errors.As(err, &serr) || serr.Function != FN_Query {
+						t.Fatalf("got error %v, want query error", err)
+					} else if row.Err() != err {
+						t.Fatalf("got row error %v, want %v", row.Err(), err)
+					}
+					if row.Columns() != nil || row.ColumnTypes() != nil {
+						t.Fatal("got columns of a failed query")
+					}
+					return
+				}
+
+				if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				if id != tt.want {
+					t.Fatalf("got id %d, want %d", id, tt.want)
+				}
+				if err := row.Err(); err != nil {
+					t.Fatalf("got row error %v", err)
+				}
+
+				if cols, want := row.Columns(), []string{"id", "name"}; !reflect.DeepEqual(cols, want) {
+					t.Fatalf("got columns %q, want %q", cols, want)
+				}
+				names := []string{}
+				for _, ct := range row.ColumnTypes() {
+					names = append(names, ct.Name())
+				}
+				if want := []string{"id", "name"}; !reflect.DeepEqual(names, want) {
+					t.Fatalf("got column types %q, want %q", names, want)
+				}
+			})
+		}
+	}
+}
+
+func TestRowClose(t *testing.T) {
+	db, st := openFake(t)
+	st.result("SELECT", []string{"id"}, []driver.Value{int64(1)})
+
+	closes := 0
+	db.Use(func(ctx context.Context, qctx *Context) {
+		closes++
+		qctx.Next()
+	}, []Function{FN_RowsClose})
+
+	if err := db.QueryRow("SELECT").Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT missing").Close(); err != nil {
+		t.Fatal(err)
+	}
+	if closes != 1 {
+		t.Fatalf("got %d handled calls, want 1", closes)
+	}
+}
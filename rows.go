@@ -0,0 +1,166 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Rows is a wrapper class around sql.Rows with middleware support. Iterating,
+// scanning and closing the rows runs the middleware handlers inherited from
+// the object that executed the query.
+type Rows struct {
+	rs    *sql.Rows
+	mdws  *scope
+	ctx   context.Context
+	query string
+	err   error
+}
+
+// newRows creates a new *Rows object from a *sql.Rows object. The context and
+// the query are passed to the handlers of the rows' functions.
+func newRows(ctx context.Context, rs *sql.Rows, mdws *scope, query string) *Rows {
+	return &Rows{
+		rs:    rs,
+		mdws:  mdws,
+		ctx:   ctx,
+		query: query,
+	}
+}
+
+// Rows returns the underlying *sql.Rows object.
+func (rs *Rows) Rows() *sql.Rows {
+	return rs.rs
+}
+
+// Node returns the node identifying the rows and its parents.
+func (rs *Rows) Node() *Node {
+	return rs.mdws.node
+}
+
+// Close closes the rows, preventing further enumeration. It calls sql.Close.
+func (rs *Rows) Close() error {
+	mdws := rs.mdws.fnHndl(FN_RowsClose)
+	if len(mdws) == 0 {
+		start := time.Now()
+		return newError(FN_RowsClose, SRC_Rows, rs.query, nil, start, rs.rs.Close())
+	}
+
+	var err error
+	var done bool
+	qctx := newContext(rs.ctx, FN_RowsClose, SRC_Rows, rs.query, nil, rs.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := rs.rs.Close(); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	err = qctx.resultErr(done)
+	return err
+}
+
+// ColumnTypes returns column information such as column type, length, and
+// nullable. It calls sql.ColumnTypes.
+func (rs *Rows) ColumnTypes() ([]*sql.ColumnType, error) {
+	return rs.rs.ColumnTypes()
+}
+
+// Columns returns the column names. It calls sql.Columns.
+func (rs *Rows) Columns() ([]string, error) {
+	return rs.rs.Columns()
+}
+
+// Err returns the error, if any, that was encountered during iteration. It
+// calls sql.Err and returns its error in an *Error with FN_Next as the
+// function. If the handlers of Next or NextResultSet added errors, an *Error
+// of the first failed call is returned instead.
+func (rs *Rows) Err() error {
+	if rs.err != nil {
+		return rs.err
+	}
+	return newError(FN_Next, SRC_Rows, rs.query, nil, time.Now(), rs.rs.Err())
+}
+
+// Next prepares the next result row for reading with the Scan method. It calls
+// sql.Next. If the middleware chain is aborted or the handlers add an error, it
+// returns false. Once an error is added, Next always returns false and the
+// error is returned by Err.
+func (rs *Rows) Next() bool {
+	if rs.err != nil {
+		return false
+	}
+
+	mdws := rs.mdws.fnHndl(FN_Next)
+	if len(mdws) == 0 {
+		return rs.rs.Next()
+	}
+
+	var ok bool
+	qctx := newContext(rs.ctx, FN_Next, SRC_Rows, rs.query, nil, rs.mdws, mdws)
+	qctx.fn = func() {
+		ok = rs.rs.Next()
+		if e := rs.rs.Err(); !ok && e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	if rs.err = qctx.err(); rs.err != nil {
+		return false
+	}
+	return ok
+}
+
+// NextResultSet prepares the next result set for reading. It calls
+// sql.NextResultSet. If the middleware chain is aborted or the handlers add an
+// error, it returns false. Errors are handled like in Next.
+func (rs *Rows) NextResultSet() bool {
+	if rs.err != nil {
+		return false
+	}
+
+	mdws := rs.mdws.fnHndl(FN_NextResultSet)
+	if len(mdws) == 0 {
+		return rs.rs.NextResultSet()
+	}
+
+	var ok bool
+	qctx := newContext(rs.ctx, FN_NextResultSet, SRC_Rows, rs.query, nil, rs.mdws, mdws)
+	qctx.fn = func() {
+		ok = rs.rs.NextResultSet()
+		if e := rs.rs.Err(); !ok && e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	if rs.err = qctx.err(); rs.err != nil {
+		return false
+	}
+	return ok
+}
+
+// Scan copies the columns in the current row into the values pointed at by
+// dest. It calls sql.Scan. The destinations are passed to the query context as
+// its args.
+func (rs *Rows) Scan(dest ...any) error {
+	mdws := rs.mdws.fnHndl(FN_Scan)
+	if len(mdws) == 0 {
+		start := time.Now()
+		return newError(FN_Scan, SRC_Rows, rs.query, dest, start, rs.rs.Scan(dest...))
+	}
+
+	var err error
+	qctx := newContext(rs.ctx, FN_Scan, SRC_Rows, rs.query, dest, rs.mdws, mdws)
+	qctx.fn = func() {
+		if e := rs.rs.Scan(qctx.Args...); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	err = qctx.err()
+	return err
+}
@@ -0,0 +1,186 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestRowsHandlers(t *testing.T) {
+	errHandler := errors.New("handler failed")
+
+	tests := []struct {
+		name string
+		fn   Function
+		hndl func(ctx context.Context, qctx *Context)
+		rows int
+		err  error
+	}{
+		{
+			name: "next",
+			fn:   FN_Next,
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Next()
+			},
+			rows: 3,
+		},
+		{
+			name: "next error",
+			fn:   FN_Next,
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Next()
+				qctx.Error(errHandler)
+			},
+			err: errHandler,
+		},
+		{
+			name: "next abort",
+			fn:   FN_Next,
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Abort()
+			},
+		},
+		{
+			name: "scan error",
+			fn:   FN_Scan,
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Next()
+				qctx.Error(errHandler)
+			},
+			err: errHandler,
+		},
+		{
+			name: "scan abort",
+			fn:   FN_Scan,
+			hndl: func(ctx context.Context, qctx *Context) {
+				*qctx.Args[0].(*int64) = 7
+				qctx.Abort()
+			},
+			rows: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.result("SELECT", []string{"id"},
+				[]driver.Value{int64(1)},
+				[]driver.Value{int64(2)},
+				[]driver.Value{int64(3)},
+			)
+
+			calls := 0
+			db.Use(func(ctx context.Context, qctx *Context) {
+				calls++
+				tt.hndl(ctx, qctx)
+			}, []Function{tt.fn})
+
+			rows, err := db.Query("SELECT")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+
+			n := 0
+			for rows.Next() {
+				var id int64
+				if err = rows.Scan(&id); err != nil {
+					break
+				}
+				if tt.fn == FN_Scan && id != 7 {
+					t.Fatalf("got id %d, want 7", id)
+				}
+				n++
+			}
+			if err == nil {
+				err = rows.Err()
+			}
+
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if n != tt.rows {
+				t.Fatalf("got %d rows, want %d", n, tt.rows)
+			}
+
+			// Iteration stays stopped once a handler failed.
+			if tt.fn == FN_Next && tt.err != nil {
+				if rows.Next() || rows.NextResultSet() {
+					t.Fatal("iteration continued after a handler failed")
+				}
+				if calls != 1 {
+					t.Fatalf("got %d handled calls, want 1", calls)
+				}
+			}
+		})
+	}
+}
+
+func TestRowsNextResultSet(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	db, st := openFake(t)
+	st.result("SELECT", []string{"id"}, []driver.Value{int64(1)})
+
+	fail := false
+	db.Use(func(ctx context.Context, qctx *Context) {
+		if qctx.Function() != FN_NextResultSet {
+			t.Errorf("got function %s, want next result set", qctx.Function())
+		}
+		qctx.Next()
+		if fail {
+			qctx.Error(errHandler)
+		}
+	}, []Function{FN_NextResultSet})
+
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if rows.NextResultSet() {
+		t.Fatal("got another result set")
+	} else if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	fail = true
+	if rows.NextResultSet() {
+		t.Fatal("got another result set")
+	}
+	if err := rows.Err(); !errors.Is(err, errHandler) {
+		t.Fatalf("got error %v, want %v", err, errHandler)
+	}
+	if rows.Next() {
+		t.Fatal("iteration continued after a handler failed")
+	}
+}
+
+func TestRowsClose(t *testing.T) {
+	db, st := openFake(t)
+	st.result("SELECT", []string{"id"}, []driver.Value{int64(1)})
+
+	closes := 0
+	db.Use(func(ctx context.Context, qctx *Context) {
+		if qctx.Query != "SELECT" || qctx.Source() != SRC_Rows {
+			t.Errorf("got %s on %s, want SELECT on rows", qctx.Query, qctx.Source())
+		}
+		closes++
+		qctx.Next()
+	}, []Function{FN_RowsClose})
+
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Fatal("got a row after closing")
+	}
+	if closes != 1 {
+		t.Fatalf("got %d handled calls, want 1", closes)
+	}
+}
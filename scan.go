@@ -0,0 +1,223 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryAll executes a query on a database, transaction or connection, and
+// scans all rows of the result into a slice of T. See ScanAll for how rows
+// are mapped to T.
+func QueryAll[T any](ctx context.Context, q Querier, query string, args ...any) ([]T, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return ScanAll[T](rows)
+}
+
+// QueryOne executes a query on a database, transaction or connection, and
+// scans the single row of the result into T. See ScanOne for how rows are
+// mapped to T.
+func QueryOne[T any](ctx context.Context, q Querier, query string, args ...any) (T, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return ScanOne[T](rows)
+}
+
+// ScanAll scans all rows into a slice of T and closes the rows. If T is a
+// struct or a pointer to a struct, columns are mapped to fields with ScanStruct.
+// Otherwise the rows must have a single column that is scanned into T. Rows of
+// prepared statements can be scanned by passing the result of
+// Stmt.QueryContext.
+func ScanAll[T any](rows *Rows) ([]T, error) {
+	res := []T{}
+	err := scanRows(rows, func() error {
+		var val T
+		if err := scanValue(rows, &val); err != nil {
+			return err
+		}
+		res = append(res, val)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ScanOne scans the single row of the rows into T and closes the rows. If the
+// rows are empty, it returns sql.ErrNoRows. If there is more than one row, it
+// returns ErrTooManyRows. T is mapped the same way as in ScanAll.
+func ScanOne[T any](rows *Rows) (T, error) {
+	var val T
+	cnt := 0
+	err := scanRows(rows, func() error {
+		if cnt++; cnt > 1 {
+			return ErrTooManyRows
+		}
+		return scanValue(rows, &val)
+	})
+	if err == nil && cnt == 0 {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return val, nil
+}
+
+// ScanStruct scans the current row into the struct pointed at by dest. Columns
+// are mapped to exported fields by the name in their db tag, or by the lower
+// case field name if the tag is missing. Fields tagged with db:"-" are skipped.
+// Fields of embedded structs are promoted, and nil embedded struct pointers are
+// allocated. Pointer fields are set to nil for NULL values. Types implementing
+// sql.Scanner are scanned as a single column. Every column must map to a field.
+func ScanStruct(rows *Rows, dest any) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("sqlm: destination must be a non-nil pointer to a struct, got %T", dest)
+	}
+	return scanStruct(rows, v.Elem())
+}
+
+// scanRows calls fn for each row of the rows and closes the rows.
+func scanRows(rows *Rows, fn func() error) error {
+	var err error
+	for err == nil && rows.Next() {
+		err = fn()
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if cerr := rows.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// scanValue scans the current row into the value pointed at by dest, either
+// by mapping columns to struct fields or by scanning a single column.
+func scanValue(rows *Rows, dest any) error {
+	v := reflect.ValueOf(dest).Elem()
+	if isStruct(v.Type()) {
+		return scanStruct(rows, v)
+	} else if v.Kind() == reflect.Pointer && isStruct(v.Type().Elem()) {
+		v.Set(reflect.New(v.Type().Elem()))
+		return scanStruct(rows, v.Elem())
+	}
+	return rows.Scan(dest)
+}
+
+// scanStruct scans the current row into the fields of a struct value.
+func scanStruct(rows *Rows, v reflect.Value) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	sm := getStructMap(v.Type())
+	dest := make([]any, len(cols))
+	for i, col := range cols {
+		path, ok := sm[col]
+		if !ok {
+			return fmt.Errorf("sqlm: missing destination for column %q in %s", col, v.Type())
+		}
+		dest[i] = fieldByPath(v, path).Addr().Interface()
+	}
+	return rows.Scan(dest...)
+}
+
+// fieldByPath returns the field of a struct value at an index path, allocating
+// nil embedded struct pointers along the way.
+func fieldByPath(v reflect.Value, path []int) reflect.Value {
+	for i, idx := range path {
+		v = v.Field(idx)
+		if i < len(path)-1 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+	}
+	return v
+}
+
+// structMap maps column names to the index paths of struct fields.
+type structMap map[string][]int
+
+// structMaps caches the structMap of each struct type.
+var structMaps sync.Map
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// isStruct returns whether a type is a struct that is mapped field by field,
+// rather than scanned as a single column.
+func isStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct &&
+		t != timeType &&
+		!reflect.PointerTo(t).Implements(scannerType)
+}
+
+// getStructMap returns the cached structMap of a struct type, creating it if
+// it does not exist yet.
+func getStructMap(t reflect.Type) structMap {
+	if sm, ok := structMaps.Load(t); ok {
+		return sm.(structMap)
+	}
+	sm := structMap{}
+	mapFields(t, nil, sm)
+	actual, _ := structMaps.LoadOrStore(t, sm)
+	return actual.(structMap)
+}
+
+// mapFields adds the fields of a struct type to a structMap. Fields of
+// embedded structs are added with their index path, and shallower fields take
+// precedence over deeper ones with the same name.
+func mapFields(t reflect.Type, path []int, sm structMap) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, hasTag := f.Tag.Lookup("db")
+		if tag == "-" {
+			continue
+		}
+
+		fpath := make([]int, len(path)+1)
+		copy(fpath, path)
+		fpath[len(path)] = i
+
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && !hasTag && isStruct(ft) {
+			// Nil pointers to unexported embedded structs cannot be allocated.
+			if f.IsExported() || f.Type.Kind() != reflect.Pointer {
+				mapFields(ft, fpath, sm)
+			}
+			continue
+		} else if !f.IsExported() {
+			continue
+		}
+
+		name := tag
+		if !hasTag || name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		if old, ok := sm[name]; !ok || len(old) > len(fpath) {
+			sm[name] = fpath
+		}
+	}
+}
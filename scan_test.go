@@ -0,0 +1,252 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type scanBase struct {
+	ID      int64
+	Created time.Time `db:"created_at"`
+}
+
+// ScanExtra is exported, since nil pointers to unexported embedded structs
+// cannot be allocated.
+type ScanExtra struct {
+	Note string
+}
+
+type scanHidden struct {
+	Note string
+}
+
+type scanUser struct {
+	scanBase
+	*ScanExtra
+	Name    string
+	Email   *string
+	Nick    sql.NullString
+	Ignored string `db:"-"`
+	secret  string
+}
+
+type scanShadow struct {
+	scanBase
+	ID string `db:"id"`
+}
+
+type scanPtrBase struct {
+	*ScanExtra
+	Name string
+}
+
+type scanHiddenPtr struct {
+	*scanHidden
+	Name string
+}
+
+func TestQueryAll(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	email := "ann@example.com"
+
+	tests := []struct {
+		name  string
+		cols  []string
+		rows  [][]driver.Value
+		query func(ctx context.Context, q Querier, query string) (any, error)
+		want  any
+		err   string
+	}{
+		{
+			name: "embedded and pointer fields",
+			cols: []string{"id", "created_at", "name", "email", "nick", "note"},
+			rows: [][]driver.Value{
+				{int64(1), created, "ann", email, "a", "n1"},
+				{int64(2), created, "bob", nil, nil, "n2"},
+			},
+			query: func(ctx context.Context, q Querier, query string) (any, error) {
+				return QueryAll[scanUser](ctx, q, query)
+			},
+			want: []scanUser{
+				{
+					scanBase:  scanBase{1, created},
+					ScanExtra: &ScanExtra{"n1"},
+					Name:      "ann",
+					Email:     &email,
+					Nick:      sql.NullString{String: "a", Valid: true},
+				},
+				{
+					scanBase:  scanBase{2, created},
+					ScanExtra: &ScanExtra{"n2"},
+					Name:      "bob",
+				},
+			},
+		},
+		{
+			name: "shallower field shadows embedded field",
+			cols: []string{"id", "created_at"},
+			rows: [][]driver.Value{{"x1", created}},
+			query: func(ctx context.Context, q Querier, query string) (any, error) {
+				return QueryAll[scanShadow](ctx, q, query)
+			},
+			want: []scanShadow{{scanBase: scanBase{Created: created}, ID: "x1"}},
+		},
+		{
+			name: "nil embedded struct pointer is allocated",
+			cols: []string{"note", "name"},
+			rows: [][]driver.Value{{"n", "ann"}},
+			query: func(ctx context.Context, q Querier, query string) (any, error) {
+				return QueryAll[*scanPtrBase](ctx, q, query)
+			},
+			want: []*scanPtrBase{{ScanExtra: &ScanExtra{"n"}, Name: "ann"}},
+		},
+		{
+			name: "unexported embedded struct pointer is skipped",
+			cols: []string{"note", "name"},
+			rows: [][]driver.Value{{"n", "ann"}},
+			query: func(ctx context.Context, q Querier, query string) (any, error) {
+				return QueryAll[scanHiddenPtr](ctx, q, query)
+			},
+			err: `sqlm: missing destination for column "note" in sqlm.scanHiddenPtr`,
+		},
+		{
+			name: "single column",
+			cols: []string{"id"},
+			rows: [][]driver.Value{{int64(1)}, {int64(2)}},
+			query: func(ctx context.Context, q Querier, query string) (any, error) {
+				return QueryAll[int64](ctx, q, query)
+			},
+			want: []int64{1, 2},
+		},
+		{
+			name: "no rows",
+			cols: []string{"id"},
+			query: func(ctx context.Context, q Querier, query string) (any, error) {
+				return QueryAll[int64](ctx, q, query)
+			},
+			want: []int64{},
+		},
+		{
+			name: "missing destination",
+			cols: []string{"id", "secret"},
+			rows: [][]driver.Value{{int64(1), "s"}},
+			query: func(ctx context.Context, q Querier, query string) (any, error) {
+				return QueryAll[scanUser](ctx, q, query)
+			},
+			err: `sqlm: missing destination for column "secret" in sqlm.scanUser`,
+		},
+		{
+			name: "skipped field",
+			cols: []string{"ignored"},
+			rows: [][]driver.Value{{"x"}},
+			query: func(ctx context.Context, q Querier, query string) (any, error) {
+				return QueryAll[scanUser](ctx, q, query)
+			},
+			err: `sqlm: missing destination for column "ignored" in sqlm.scanUser`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.result("SELECT", tt.cols, tt.rows...)
+
+			got, err := tt.query(context.Background(), db, "SELECT")
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryOne(t *testing.T) {
+	tests := []struct {
+		name string
+		rows [][]driver.Value
+		want int64
+		err  error
+	}{
+		{name: "one row", rows: [][]driver.Value{{int64(3)}}, want: 3},
+		{name: "no rows", err: sql.ErrNoRows},
+		{name: "many rows", rows: [][]driver.Value{{int64(1)}, {int64(2)}}, err: ErrTooManyRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.result("SELECT", []string{"id"}, tt.rows...)
+
+			got, err := QueryOne[int64](context.Background(), db, "SELECT")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanStruct(t *testing.T) {
+	db, st := openFake(t)
+	st.result("SELECT", []string{"note", "name"}, []driver.Value{"n", "ann"})
+
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var dest scanPtrBase
+	if err := ScanStruct(rows, &dest); err == nil {
+		t.Fatal("scanning before Next succeeded")
+	}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := ScanStruct(rows, dest); err == nil {
+		t.Fatal("scanning into a non-pointer succeeded")
+	}
+	if err := ScanStruct(rows, &dest); err != nil {
+		t.Fatal(err)
+	}
+	if dest.ScanExtra == nil || dest.Note != "n" || dest.Name != "ann" {
+		t.Fatalf("got %+v", dest)
+	}
+}
+
+func TestGetStructMap(t *testing.T) {
+	want := structMap{
+		"id":         {0, 0},
+		"created_at": {0, 1},
+		"note":       {1, 0},
+		"name":       {2},
+		"email":      {3},
+		"nick":       {4},
+	}
+
+	typ := reflect.TypeOf(scanUser{})
+	if sm := getStructMap(typ); !reflect.DeepEqual(sm, want) {
+		t.Fatalf("got %v, want %v", sm, want)
+	}
+	if sm := getStructMap(typ); !reflect.DeepEqual(sm, want) {
+		t.Fatalf("got cached %v, want %v", sm, want)
+	}
+	if sm := getStructMap(reflect.TypeOf(scanShadow{})); !reflect.DeepEqual(sm["id"], []int{1}) {
+		t.Fatalf("got id path %v, want [1]", sm["id"])
+	}
+}
@@ -0,0 +1,376 @@
+package sqlm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Middleware is a handle to a middleware handler attached to a sql object. It
+// can be used to inspect or remove the handler at runtime.
+type Middleware struct {
+	hndl   handler
+	fns    []Function
+	name   string
+	prio   int
+	before []string
+	after  []string
+	match  Matcher
+	sc     *scope
+}
+
+// UseOption configures a middleware handler when it is attached.
+type UseOption func(*Middleware)
+
+// Named sets the name of the middleware handler. Names must be unique within
+// the handlers attached to the same sql object and are used for ordering
+// constraints and introspection.
+func Named(name string) UseOption {
+	return func(m *Middleware) {
+		m.name = name
+	}
+}
+
+// Priority sets the priority of the middleware handler. Handlers with higher
+// priority run earlier on the chain. Handlers with the same priority run in
+// the order they were attached. A handler constrained to run before handlers
+// with a higher priority takes the highest of their priorities. The default
+// priority is 0.
+func Priority(prio int) UseOption {
+	return func(m *Middleware) {
+		m.prio = prio
+	}
+}
+
+// Before constrains the middleware handler to run before the named handlers
+// attached to the same sql object. Names that are not attached are ignored.
+func Before(names ...string) UseOption {
+	return func(m *Middleware) {
+		m.before = append(m.before, names...)
+	}
+}
+
+// After constrains the middleware handler to run after the named handlers
+// attached to the same sql object. Names that are not attached are ignored.
+func After(names ...string) UseOption {
+	return func(m *Middleware) {
+		m.after = append(m.after, names...)
+	}
+}
+
+// Name returns the name of the middleware handler.
+func (m *Middleware) Name() string {
+	return m.name
+}
+
+// Priority returns the priority of the middleware handler.
+func (m *Middleware) Priority() int {
+	return m.prio
+}
+
+// Functions returns the list of sql functions the handler is attached to. The
+// list is empty if the handler is attached to all sql functions.
+func (m *Middleware) Functions() []Function {
+	return append([]Function(nil), m.fns...)
+}
+
+// Remove detaches the middleware handler from the sql object it was attached
+// to. Calls in progress may still run the handler. Removing a handler more
+// than once has no effect. The function is thread safe.
+func (m *Middleware) Remove() {
+	m.sc.remove(m)
+}
+
+// chain is an immutable snapshot of the middleware handlers in a scope. The
+// handlers are grouped by sql function, and handlers attached to all sql
+// functions are also listed separately for functions without any handlers of
+// their own.
+type chain struct {
+	list []*Middleware
+	mdws map[Function][]*Middleware
+	all  []*Middleware
+}
+
+// newChain creates a snapshot from a list of middleware handles in attachment
+// order. The handles are sorted by priority and ordering constraints, then
+// grouped by sql function. The function returns an error if the ordering
+// constraints contain a cycle.
+func newChain(list []*Middleware) (*chain, error) {
+	sorted, err := sortMiddlewares(list)
+	if err != nil {
+		return nil, err
+	}
+
+	mdws := map[Function][]*Middleware{}
+	for _, m := range sorted {
+		for _, fn := range m.fns {
+			mdws[fn] = nil
+		}
+	}
+
+	all := []*Middleware{}
+	for _, m := range sorted {
+		if len(m.fns) == 0 {
+			all = append(all, m)
+			for fn := range mdws {
+				mdws[fn] = append(mdws[fn], m)
+			}
+		} else {
+			for _, fn := range m.fns {
+				mdws[fn] = append(mdws[fn], m)
+			}
+		}
+	}
+	return &chain{list: list, mdws: mdws, all: all}, nil
+}
+
+// lookup returns the middleware handlers of a sql function.
+func (chn *chain) lookup(fn Function) []*Middleware {
+	if mdws, ok := chn.mdws[fn]; ok {
+		return mdws
+	}
+	return chn.all
+}
+
+// sortMiddlewares orders middleware handles by descending priority and
+// attachment order, while satisfying the Before and After constraints. A
+// handle that must run before others takes the highest priority among them,
+// so constraints do not move unrelated handles ahead of higher priorities.
+func sortMiddlewares(list []*Middleware) ([]*Middleware, error) {
+	ranked := append([]*Middleware(nil), list...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].prio > ranked[j].prio
+	})
+
+	names := map[string]*Middleware{}
+	for _, m := range ranked {
+		if m.name != "" {
+			names[m.name] = m
+		}
+	}
+
+	// deps holds the handles that must run before each handle, and dependents
+	// the handles that must run after each handle.
+	deps := map[*Middleware]map[*Middleware]bool{}
+	dependents := map[*Middleware]map[*Middleware]bool{}
+	addDep := func(m, dep *Middleware) {
+		if deps[m] == nil {
+			deps[m] = map[*Middleware]bool{}
+		}
+		if dependents[dep] == nil {
+			dependents[dep] = map[*Middleware]bool{}
+		}
+		deps[m][dep] = true
+		dependents[dep][m] = true
+	}
+	for _, m := range ranked {
+		for _, name := range m.before {
+			if o, ok := names[name]; ok && o != m {
+				addDep(o, m)
+			}
+		}
+		for _, name := range m.after {
+			if o, ok := names[name]; ok && o != m {
+				addDep(m, o)
+			}
+		}
+	}
+
+	order, err := topoSort(ranked, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dependents come later in the order, so the effective priorities are
+	// computed in reverse.
+	eff := map[*Middleware]int{}
+	for i := len(order) - 1; i >= 0; i-- {
+		m := order[i]
+		prio := m.prio
+		for d := range dependents[m] {
+			if eff[d] > prio {
+				prio = eff[d]
+			}
+		}
+		eff[m] = prio
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return eff[ranked[i]] > eff[ranked[j]]
+	})
+	return topoSort(ranked, deps)
+}
+
+// topoSort orders ranked middleware handles so that every handle runs after
+// its dependencies. When multiple handles are free to run next, the one
+// earliest in the ranking is picked. The function returns an error if the
+// dependencies contain a cycle.
+func topoSort(
+	ranked []*Middleware,
+	deps map[*Middleware]map[*Middleware]bool,
+) ([]*Middleware, error) {
+	sorted := make([]*Middleware, 0, len(ranked))
+	done := map[*Middleware]bool{}
+	for len(sorted) < len(ranked) {
+		var next *Middleware
+		for _, m := range ranked {
+			if done[m] {
+				continue
+			}
+			ready := true
+			for dep := range deps[m] {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = m
+				break
+			}
+		}
+		if next == nil {
+			return nil, errors.New("middleware ordering constraints contain a cycle")
+		}
+		done[next] = true
+		sorted = append(sorted, next)
+	}
+	return sorted, nil
+}
+
+// scope is a layer of middleware handlers attached to a single sql object on
+// top of the handlers inherited from its parent. Objects created from the
+// sql object inherit the scope as their parent. Handlers are stored in a copy
+// on write snapshot, so they can be added and removed while calls are in
+// progress.
+type scope struct {
+	parent *scope
+	mtx    sync.Mutex
+	chn    atomic.Pointer[chain]
+	sess   *Session
+	node   *Node
+}
+
+// newScope creates a new empty scope inheriting from a parent for a sql object
+// of a source type. The parent is nil for the scope of a database object.
+func newScope(parent *scope, src Source) *scope {
+	var pnode *Node
+	if parent != nil {
+		pnode = parent.node
+	}
+	return &scope{
+		parent: parent,
+		node:   newNode(src, pnode),
+	}
+}
+
+// session returns the session store of the closest scope in the inheritance
+// chain that has one, or nil if there is none.
+func (sc *scope) session() *Session {
+	for ; sc != nil; sc = sc.parent {
+		if sc.sess != nil {
+			return sc.sess
+		}
+	}
+	return nil
+}
+
+// use attaches a middleware handler to specific sql functions in the scope.
+// The function panics if the handler is nil, the list of functions is empty
+// without a matcher, the name is already used in the scope or the ordering
+// constraints contain a cycle.
+func (sc *scope) use(
+	mdw func(context.Context, *Context),
+	fns []Function,
+	opts []UseOption,
+) *Middleware {
+	if mdw == nil {
+		panic("middleware is nil")
+	}
+
+	m := &Middleware{
+		hndl: mdw,
+		fns:  append([]Function(nil), fns...),
+		sc:   sc,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if len(fns) == 0 && m.match == nil {
+		panic("function list is empty")
+	}
+
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	var list []*Middleware
+	if chn := sc.chn.Load(); chn != nil {
+		list = make([]*Middleware, 0, len(chn.list)+1)
+		list = append(list, chn.list...)
+	}
+	for _, lm := range list {
+		if m.name != "" && lm.name == m.name {
+			panic("middleware name is already used")
+		}
+	}
+
+	chn, err := newChain(append(list, m))
+	if err != nil {
+		panic(err.Error())
+	}
+	sc.chn.Store(chn)
+	return m
+}
+
+// remove detaches a middleware handler from the scope if it is attached.
+func (sc *scope) remove(m *Middleware) {
+	sc.mtx.Lock()
+	defer sc.mtx.Unlock()
+
+	chn := sc.chn.Load()
+	if chn == nil {
+		return
+	}
+
+	list := make([]*Middleware, 0, len(chn.list))
+	for _, lm := range chn.list {
+		if lm != m {
+			list = append(list, lm)
+		}
+	}
+	if len(list) != len(chn.list) {
+		// Removing handles cannot introduce an ordering cycle.
+		chn, _ = newChain(list)
+		sc.chn.Store(chn)
+	}
+}
+
+// fnHndl returns the inherited middleware handlers for a sql function followed
+// by the handlers of the scope.
+func (sc *scope) fnHndl(fn Function) []*Middleware {
+	var inh, own []*Middleware
+	if sc.parent != nil {
+		inh = sc.parent.fnHndl(fn)
+	}
+	if chn := sc.chn.Load(); chn != nil {
+		own = chn.lookup(fn)
+	}
+
+	if len(own) == 0 {
+		return inh
+	} else if len(inh) == 0 {
+		return own
+	}
+
+	mdws := make([]*Middleware, 0, len(inh)+len(own))
+	mdws = append(mdws, inh...)
+	return append(mdws, own...)
+}
+
+// middlewares returns the inherited middleware handles for a sql function
+// followed by the handles of the scope, in the order they run.
+func (sc *scope) middlewares(fn Function) []*Middleware {
+	return append([]*Middleware(nil), sc.fnHndl(fn)...)
+}
@@ -0,0 +1,173 @@
+package sqlm
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSortMiddlewares(t *testing.T) {
+	type spec struct {
+		name   string
+		prio   int
+		before []string
+		after  []string
+	}
+
+	tests := []struct {
+		name  string
+		specs []spec
+		want  string
+		err   bool
+	}{
+		{
+			name:  "attachment order",
+			specs: []spec{{name: "a"}, {name: "b"}, {name: "c"}},
+			want:  "abc",
+		},
+		{
+			name:  "descending priority",
+			specs: []spec{{name: "a"}, {name: "b", prio: 5}, {name: "c", prio: 1}},
+			want:  "bca",
+		},
+		{
+			name:  "before",
+			specs: []spec{{name: "a"}, {name: "b", before: []string{"a"}}},
+			want:  "ba",
+		},
+		{
+			name:  "after",
+			specs: []spec{{name: "a", after: []string{"b"}}, {name: "b"}},
+			want:  "ba",
+		},
+		{
+			name:  "after overrides priority",
+			specs: []spec{{name: "a"}, {name: "b", prio: 5, after: []string{"a"}}},
+			want:  "ab",
+		},
+		{
+			name: "constrained handler takes higher priority",
+			specs: []spec{
+				{name: "a"},
+				{name: "b", prio: 5},
+				{name: "c", before: []string{"b"}},
+			},
+			want: "cba",
+		},
+		{
+			name: "transitive priority",
+			specs: []spec{
+				{name: "a"},
+				{name: "b", before: []string{"a"}},
+				{name: "c", prio: 10},
+				{name: "d", prio: 20, after: []string{"a"}},
+			},
+			want: "badc",
+		},
+		{
+			name: "unknown and self references are ignored",
+			specs: []spec{
+				{name: "a", before: []string{"x", "a"}},
+				{name: "b", prio: 1, after: []string{"y"}},
+			},
+			want: "ba",
+		},
+		{
+			name: "cycle",
+			specs: []spec{
+				{name: "a", before: []string{"b"}},
+				{name: "b", before: []string{"a"}},
+			},
+			err: true,
+		},
+		{
+			name: "indirect cycle",
+			specs: []spec{
+				{name: "a", before: []string{"b"}},
+				{name: "b", before: []string{"c"}},
+				{name: "c", before: []string{"a"}},
+			},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := []*Middleware{}
+			for _, s := range tt.specs {
+				list = append(list, &Middleware{
+					name:   s.name,
+					prio:   s.prio,
+					before: s.before,
+					after:  s.after,
+				})
+			}
+
+			sorted, err := sortMiddlewares(list)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected a cycle error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			names := []string{}
+			for _, m := range sorted {
+				names = append(names, m.name)
+			}
+			if got := strings.Join(names, ""); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeUse(t *testing.T) {
+	db, _ := openFake(t)
+
+	got := []string{}
+	hndl := func(name string) func(context.Context, *Context) {
+		return func(ctx context.Context, qctx *Context) {
+			got = append(got, name)
+			qctx.Next()
+		}
+	}
+
+	db.Use(hndl("db"), []Function{FN_Exec}, Named("db"))
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	first := tx.Use(hndl("tx"), []Function{FN_Exec}, Named("tx"), Priority(10))
+	tx.Use(hndl("all"), nil, When(MatchKind(KIND_Write)))
+
+	if _, err := tx.Exec("INSERT"); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(got, ","); s != "db,tx,all" {
+		t.Fatalf("got %s, want db,tx,all", s)
+	}
+
+	got = got[:0]
+	first.Remove()
+	if _, err := tx.Exec("SELECT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT"); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(got, ","); s != "db,db" {
+		t.Fatalf("got %s, want db,db", s)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate name did not panic")
+		}
+	}()
+	db.Use(hndl("db"), []Function{FN_Exec}, Named("db"))
+}
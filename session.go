@@ -0,0 +1,50 @@
+package sqlm
+
+import (
+	"sync"
+)
+
+// Session is a thread safe value store shared by every call made on a
+// transaction or a connection, including calls on their prepared statements
+// and rows. Middleware can use it to correlate the calls of one transaction or
+// connection.
+type Session struct {
+	mtx  sync.Mutex
+	vals map[string]any
+}
+
+// newSession creates a new empty session store.
+func newSession() *Session {
+	return &Session{vals: map[string]any{}}
+}
+
+// Set assigns some value to a key in the session.
+func (s *Session) Set(key string, val any) {
+	s.mtx.Lock()
+	s.vals[key] = val
+	s.mtx.Unlock()
+}
+
+// Get retrieves some value from the session by a key.
+func (s *Session) Get(key string) (val any, ok bool) {
+	s.mtx.Lock()
+	val, ok = s.vals[key]
+	s.mtx.Unlock()
+	return val, ok
+}
+
+// Delete deletes the value from the session under some key.
+func (s *Session) Delete(key string) {
+	s.mtx.Lock()
+	delete(s.vals, key)
+	s.mtx.Unlock()
+}
+
+// Update atomically replaces the value under some key with the result of fn,
+// which receives the current value. It is useful for accumulating statistics.
+func (s *Session) Update(key string, fn func(val any, ok bool) any) {
+	s.mtx.Lock()
+	val, ok := s.vals[key]
+	s.vals[key] = fn(val, ok)
+	s.mtx.Unlock()
+}
@@ -3,11 +3,12 @@ package sqlm
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 type Stmt struct {
 	st    *sql.Stmt
-	mdws  hndl
+	mdws  *scope
 	query string
 }
 
@@ -16,9 +17,52 @@ func (st *Stmt) Statement() *sql.Stmt {
 	return st.st
 }
 
+// Node returns the node identifying the statement and its parents.
+func (st *Stmt) Node() *Node {
+	return st.mdws.node
+}
+
+// Use attaches a middleware handler to specific sql functions of the
+// statement. The handler runs after the handlers inherited from the parent
+// object and only applies to the statement. Options and panics are the same as
+// in DB.Use. The returned handle can be used to remove the handler. The
+// function is thread safe.
+func (st *Stmt) Use(
+	mdw func(context.Context, *Context),
+	fns []Function,
+	opts ...UseOption,
+) *Middleware {
+	return st.mdws.use(mdw, fns, opts)
+}
+
+// Middlewares returns the middleware handlers that run for a sql function
+// called on the statement, in the order they run.
+func (st *Stmt) Middlewares(fn Function) []*Middleware {
+	return st.mdws.middlewares(fn)
+}
+
 // Close closes the statement. It calls sql.Close.
 func (st *Stmt) Close() error {
-	return st.st.Close()
+	mdws := st.mdws.fnHndl(FN_Close)
+	if len(mdws) == 0 {
+		start := time.Now()
+		return newError(FN_Close, SRC_Statement, st.query, nil, start, st.st.Close())
+	}
+
+	var err error
+	var done bool
+	ctx := context.Background()
+	qctx := newContext(ctx, FN_Close, SRC_Statement, st.query, nil, st.mdws, mdws)
+	qctx.fn = func() {
+		done = true
+		if e := st.st.Close(); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	err = qctx.resultErr(done)
+	return err
 }
 
 // Exec calls ExecContext with context.Background and args.
@@ -32,51 +76,68 @@ func (st *Stmt) Exec(query string, args ...any) (sql.Result, error) {
 func (st *Stmt) ExecContext(ctx context.Context, args ...any) (sql.Result, error) {
 	mdws := st.mdws.fnHndl(FN_Exec)
 	if len(mdws) == 0 {
-		return st.st.ExecContext(ctx, args...)
+		start := time.Now()
+		res, err := st.st.ExecContext(ctx, args...)
+		return res, newError(FN_Exec, SRC_Statement, st.query, args, start, err)
 	}
 
-	var res sql.Result
 	var err error
-	qctx := newContext(ctx, FN_Exec, SRC_Statement, st.query, args, mdws)
+	qctx := newContext(ctx, FN_Exec, SRC_Statement, st.query, args, st.mdws, mdws)
 	qctx.fn = func() {
-		if r, e := st.st.ExecContext(ctx, qctx.Args...); e != nil {
+		if r, e := st.st.ExecContext(qctx.ctx, qctx.Args...); e != nil {
 			qctx.Error(e)
 		} else {
-			res = r
+			qctx.result = r
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return res, err
+	err = qctx.err()
+	return qctx.execResult(), err
 }
 
 // Query calls QueryContext with context.Background and args.
-func (st *Stmt) Query(args ...any) (*sql.Rows, error) {
+func (st *Stmt) Query(args ...any) (*Rows, error) {
 	return st.QueryContext(context.Background(), args...)
 }
 
 // QueryContext executes a prepared query statement with the given arguments.
 // It calls sql.QueryContext. The query string is passed to the query context,
 // but changes to it will not change the prepared statement.
-func (st *Stmt) QueryContext(ctx context.Context, args ...any) (*sql.Rows, error) {
+func (st *Stmt) QueryContext(ctx context.Context, args ...any) (*Rows, error) {
 	mdws := st.mdws.fnHndl(FN_Query)
 	if len(mdws) == 0 {
-		return st.st.QueryContext(ctx, args...)
+		start := time.Now()
+		if sqlrows, err := st.st.QueryContext(ctx, args...); err != nil {
+			return nil, newError(FN_Query, SRC_Statement, st.query, args, start, err)
+		} else {
+			return newRows(ctx, sqlrows, newScope(st.mdws, SRC_Rows), st.query), nil
+		}
 	}
 
-	var rows *sql.Rows
 	var err error
-	qctx := newContext(ctx, FN_Query, SRC_Statement, st.query, args, mdws)
+	qctx := newContext(ctx, FN_Query, SRC_Statement, st.query, args, st.mdws, mdws)
 	qctx.fn = func() {
-		if r, e := st.st.QueryContext(ctx, qctx.Args...); e != nil {
+		if r, e := st.st.QueryContext(qctx.ctx, qctx.Args...); e != nil {
 			qctx.Error(e)
 		} else {
-			rows = r
+			qctx.rows = newRows(qctx.ctx, r, newScope(st.mdws, SRC_Rows), st.query)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return rows, err
+	err = qctx.resultErr(qctx.rows != nil)
+	return qctx.rows, err
+}
+
+// QueryRow calls QueryRowContext with context.Background and args.
+func (st *Stmt) QueryRow(args ...any) *Row {
+	return st.QueryRowContext(context.Background(), args...)
+}
+
+// QueryRowContext executes a prepared query statement that is expected to
+// return a single row. It calls QueryContext, so it runs the handlers of
+// FN_Query. Errors are deferred until the row's Scan method is called.
+func (st *Stmt) QueryRowContext(ctx context.Context, args ...any) *Row {
+	return newRow(st.QueryContext(ctx, args...))
 }
@@ -3,12 +3,113 @@ package sqlm
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Tx is a wrapper class around sql.Tx with middleware support. A Tx can also
+// be a nested transaction created on a savepoint of its parent transaction,
+// in which case committing releases the savepoint and rolling back rolls back
+// to it.
 type Tx struct {
-	tx   *sql.Tx
-	mdws hndl
-	done bool
+	tx    *sql.Tx
+	mdws  *scope
+	db    *DB
+	state atomic.Int32
+
+	hmtx     sync.Mutex
+	onCommit []func(ctx context.Context) error
+	onRollbk []func(ctx context.Context, err error) error
+	herr     error
+
+	parent *Tx
+	name   string
+	seq    *atomic.Uint64
+}
+
+// newTx creates a new top level *Tx object from a *sql.Tx object. The
+// transaction gets a new session store.
+func newTx(tx *sql.Tx, mdws *scope, db *DB) *Tx {
+	mdws.sess = newSession()
+	return &Tx{
+		tx:   tx,
+		mdws: mdws,
+		db:   db,
+		seq:  &atomic.Uint64{},
+	}
+}
+
+// nested creates a nested *Tx object on a savepoint of the transaction. The
+// nested transaction shares the session store of the transaction.
+func (tx *Tx) nested(name string) *Tx {
+	return &Tx{
+		tx:     tx.tx,
+		mdws:   newScope(tx.mdws, SRC_Transaction),
+		db:     tx.db,
+		parent: tx,
+		name:   name,
+		seq:    tx.seq,
+	}
+}
+
+// txKey is the context.Context key of an ambient transaction.
+type txKey struct{}
+
+// ContextWithTx returns a copy of the context.Context with a transaction
+// stored in it. Exec, Query and Prepare calls on the database object that
+// created the transaction join it when called with the returned context.
+func ContextWithTx(ctx context.Context, tx *Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the transaction stored in the context.Context, or nil
+// if there is none.
+func TxFromContext(ctx context.Context) *Tx {
+	tx, _ := ctx.Value(txKey{}).(*Tx)
+	return tx
+}
+
+// withTx begins a transaction and runs fn with it. The transaction is
+// committed if fn returns nil, and rolled back if fn returns an error or
+// panics. Panics are re-raised after the rollback. If the handlers of
+// FN_Commit leave the transaction active, it is rolled back so the connection
+// is released. It returns whether the transaction was committed, which can be
+// the case even with an error if a handler of FN_Commit failed after the
+// commit.
+func withTx(
+	ctx context.Context,
+	b Beginner,
+	opts *sql.TxOptions,
+	fn func(tx *Tx) error,
+) (bool, error) {
+	tx, err := b.BeginTx(ctx, opts)
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.RollbackContext(ctx)
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if rerr := tx.RollbackContext(ctx); rerr != nil {
+			return false, errors.Join(err, rerr)
+		}
+		return false, err
+	}
+	err = tx.CommitContext(ctx)
+	if tx.State() == TX_Active {
+		if rerr := tx.RollbackContext(ctx); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+	}
+	return tx.State() == TX_Committed, err
 }
 
 // Transaction returns the underlying *sql.Tx object.
@@ -16,31 +117,237 @@ func (tx *Tx) Transaction() *sql.Tx {
 	return tx.tx
 }
 
+// Node returns the node identifying the transaction and its parents.
+func (tx *Tx) Node() *Node {
+	return tx.mdws.node
+}
+
+// Session returns the session store of the transaction.
+func (tx *Tx) Session() *Session {
+	return tx.mdws.session()
+}
+
+// txCompleting is the internal state of a transaction while the underlying
+// commit or rollback is executing. It is reported as TX_Active.
+const txCompleting TxState = -1
+
+// State returns the state of the transaction. A nested transaction that is
+// still active reports the state of its parent once the parent has completed.
+func (tx *Tx) State() TxState {
+	st := TxState(tx.state.Load())
+	if st == txCompleting {
+		return TX_Active
+	} else if st == TX_Active && tx.parent != nil {
+		return tx.parent.State()
+	}
+	return st
+}
+
+// OnCommit registers a hook that runs after the transaction is committed,
+// following the handlers of FN_Commit. Hooks run in the order they were
+// registered. Hooks of a nested transaction are handed over to its parent when
+// the savepoint is released, and run when the top level transaction commits.
+// The function is thread safe.
+func (tx *Tx) OnCommit(fn func(ctx context.Context) error) {
+	tx.hmtx.Lock()
+	tx.onCommit = append(tx.onCommit, fn)
+	tx.hmtx.Unlock()
+}
+
+// OnRollback registers a hook that runs after the transaction is rolled back,
+// or after a commit failed. The hook receives the error of the rollback or the
+// failed commit. Hooks run in the order they were registered. Hooks of a nested
+// transaction run when rolling back to its savepoint, or are handed over to its
+// parent when the savepoint is released. The function is thread safe.
+func (tx *Tx) OnRollback(fn func(ctx context.Context, err error) error) {
+	tx.hmtx.Lock()
+	tx.onRollbk = append(tx.onRollbk, fn)
+	tx.hmtx.Unlock()
+}
+
+// HookError is reported by HookErrors when a transaction completed, but some
+// of its commit or rollback hooks failed. The state of the transaction tells
+// whether it was committed.
+type HookError struct {
+	Errs []error
+}
+
+// Error returns the error messages of the failed hooks.
+func (e *HookError) Error() string {
+	return "sqlm: transaction hooks failed: " + errors.Join(e.Errs...).Error()
+}
+
+// Unwrap returns the errors of the failed hooks.
+func (e *HookError) Unwrap() []error {
+	return e.Errs
+}
+
+// HookErrors returns a *HookError with the errors of the hooks that failed
+// after the transaction completed, or nil if none failed. Hook errors are not
+// returned by Commit and Rollback, so they do not mask the result of the
+// completion. The function is thread safe.
+func (tx *Tx) HookErrors() error {
+	tx.hmtx.Lock()
+	defer tx.hmtx.Unlock()
+	return tx.herr
+}
+
+// runHooks runs the hooks matching the state of the completed transaction and
+// records their errors for HookErrors. The rollback hooks receive the error of
+// the completion. Hooks of a released nested transaction are handed over to
+// its parent instead.
+func (tx *Tx) runHooks(ctx context.Context, err error) {
+	tx.hmtx.Lock()
+	onCommit, onRollbk := tx.onCommit, tx.onRollbk
+	tx.onCommit, tx.onRollbk = nil, nil
+	tx.hmtx.Unlock()
+
+	st := TxState(tx.state.Load())
+	if st == TX_Committed && tx.parent != nil {
+		tx.parent.hmtx.Lock()
+		tx.parent.onCommit = append(tx.parent.onCommit, onCommit...)
+		tx.parent.onRollbk = append(tx.parent.onRollbk, onRollbk...)
+		tx.parent.hmtx.Unlock()
+		return
+	}
+
+	herrs := []error{}
+	if st == TX_Committed {
+		for _, fn := range onCommit {
+			if e := fn(ctx); e != nil {
+				herrs = append(herrs, e)
+			}
+		}
+	} else {
+		for _, fn := range onRollbk {
+			if e := fn(ctx, err); e != nil {
+				herrs = append(herrs, e)
+			}
+		}
+	}
+
+	if len(herrs) != 0 {
+		tx.hmtx.Lock()
+		tx.herr = &HookError{herrs}
+		tx.hmtx.Unlock()
+	}
+}
+
+// complete claims the completion of the transaction and runs fn, which
+// commits or rolls back the underlying transaction. The state is set to st if
+// fn succeeds and to TX_Failed if it fails or panics. If the transaction is no
+// longer active, fn is not called and sql.ErrTxDone is returned. It returns
+// whether the completion was claimed.
+func (tx *Tx) complete(st TxState, fn func() error) (bool, error) {
+	if !tx.state.CompareAndSwap(int32(TX_Active), int32(txCompleting)) {
+		return false, sql.ErrTxDone
+	}
+
+	fin := TX_Failed
+	defer func() {
+		tx.state.Store(int32(fin))
+	}()
+
+	err := fn()
+	if err == nil {
+		fin = st
+	}
+	return true, err
+}
+
+// completeErr returns the error of a chain completing the transaction. If the
+// handlers did not let the chain complete the transaction and added no error,
+// it returns an *Error wrapping sql.ErrTxDone if a handler completed the
+// transaction otherwise, and an *Error wrapping ErrNoResult if the transaction
+// is still active.
+func (tx *Tx) completeErr(qctx *Context, done bool) error {
+	if !done && len(qctx.errs) == 0 && tx.State() != TX_Active {
+		qctx.Error(sql.ErrTxDone)
+	}
+	return qctx.resultErr(done)
+}
+
+// Use attaches a middleware handler to specific sql functions of the
+// transaction. The handler runs after the handlers inherited from the parent
+// object and only applies to the transaction and the prepared statements
+// created from it. Options and panics are the same as in DB.Use. The returned
+// handle can be used to remove the handler. The function is thread safe.
+func (tx *Tx) Use(
+	mdw func(context.Context, *Context),
+	fns []Function,
+	opts ...UseOption,
+) *Middleware {
+	return tx.mdws.use(mdw, fns, opts)
+}
+
+// Middlewares returns the middleware handlers that run for a sql function
+// called on the transaction, in the order they run.
+func (tx *Tx) Middlewares(fn Function) []*Middleware {
+	return tx.mdws.middlewares(fn)
+}
+
 // Commit calls CommitContext with context.Background.
 func (tx *Tx) Commit() error {
 	return tx.CommitContext(context.Background())
 }
 
 // CommitContext commits the transaction. It calls sql.Commit. If the
-// transaction has already been committed or rolled back, it's a noop and
-// nothing will execute.
+// transaction has already been committed, it's a noop and nothing will
+// execute. If it has been rolled back or failed, nothing will execute and an
+// *Error wrapping sql.ErrTxDone is returned. Committing a nested transaction releases its
+// savepoint and runs the handlers of FN_Release instead. After the transaction
+// is committed, the hooks registered with OnCommit run. If the commit fails,
+// the hooks registered with OnRollback run instead. Hook errors are not
+// returned, and are reported by HookErrors instead. No lock is held while the
+// handlers run, so a handler can abort the commit and roll back the
+// transaction instead, in which case sql.ErrTxDone is returned. If the
+// handlers abort the commit and leave the transaction active, an *Error
+// wrapping ErrNoResult is returned.
 func (tx *Tx) CommitContext(ctx context.Context) error {
+	if st := tx.State(); st != TX_Active {
+		if st == TX_Committed {
+			return nil
+		} else if tx.parent != nil {
+			return newError(FN_Release, SRC_Transaction, "", nil, time.Now(), sql.ErrTxDone)
+		}
+		return newError(FN_Commit, SRC_Transaction, "", nil, time.Now(), sql.ErrTxDone)
+	}
+
+	done, err := tx.commit(ctx)
+	if done {
+		tx.runHooks(ctx, err)
+	}
+	return err
+}
+
+// commit commits the transaction. It returns whether the commit was executed
+// and completed the transaction.
+func (tx *Tx) commit(ctx context.Context) (bool, error) {
+	if tx.parent != nil {
+		query := tx.db.dial.ReleaseSavepoint(tx.name)
+		return tx.savepointExec(ctx, FN_Release, query, TX_Committed)
+	}
+
 	mdws := tx.mdws.fnHndl(FN_Commit)
 	if len(mdws) == 0 {
-		return tx.tx.Commit()
+		start := time.Now()
+		done, err := tx.complete(TX_Committed, tx.tx.Commit)
+		return done, newError(FN_Commit, SRC_Transaction, "", nil, start, err)
 	}
 
 	var err error
-	qctx := newContext(ctx, FN_Commit, SRC_Transaction, "", nil, mdws)
+	var done bool
+	qctx := newContext(ctx, FN_Commit, SRC_Transaction, "", nil, tx.mdws, mdws)
 	qctx.fn = func() {
-		if e := tx.tx.Commit(); e != nil {
+		var e error
+		if done, e = tx.complete(TX_Committed, tx.tx.Commit); e != nil {
 			qctx.Error(e)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return err
+	err = tx.completeErr(qctx, done)
+	return done, err
 }
 
 // Rollback calls RollbackContext with context.Background.
@@ -49,25 +356,146 @@ func (tx *Tx) Rollback() error {
 }
 
 // Rollback aborts the transaction. It calls sql.Rollback. If the transaction
-// has already been committed or rolled back, it's a noop and nothing will
-// execute.
+// has already been committed, rolled back or failed, it's a noop and nothing
+// will execute, so it is safe to defer. Rolling back a nested transaction rolls
+// back to its savepoint and runs the handlers of FN_RollbackTo instead. If the
+// handlers abort the rollback, errors are returned like in CommitContext. After
+// the transaction is rolled back, the hooks registered with OnRollback run.
+// Hook errors are reported by HookErrors.
 func (tx *Tx) RollbackContext(ctx context.Context) error {
+	if tx.State() != TX_Active {
+		return nil
+	}
+
+	done, err := tx.rollback(ctx)
+	if done {
+		tx.runHooks(ctx, err)
+	}
+	return err
+}
+
+// rollback rolls back the transaction. It returns whether the rollback was
+// executed and completed the transaction.
+func (tx *Tx) rollback(ctx context.Context) (bool, error) {
+	if tx.parent != nil {
+		query := tx.db.dial.RollbackToSavepoint(tx.name)
+		return tx.savepointExec(ctx, FN_RollbackTo, query, TX_RolledBack)
+	}
+
 	mdws := tx.mdws.fnHndl(FN_Rollback)
 	if len(mdws) == 0 {
-		return tx.tx.Rollback()
+		start := time.Now()
+		done, err := tx.complete(TX_RolledBack, tx.tx.Rollback)
+		return done, newError(FN_Rollback, SRC_Transaction, "", nil, start, err)
 	}
 
 	var err error
-	qctx := newContext(ctx, FN_Rollback, SRC_Transaction, "", nil, mdws)
+	var done bool
+	qctx := newContext(ctx, FN_Rollback, SRC_Transaction, "", nil, tx.mdws, mdws)
 	qctx.fn = func() {
-		if e := tx.tx.Rollback(); e != nil {
+		var e error
+		if done, e = tx.complete(TX_RolledBack, tx.tx.Rollback); e != nil {
 			qctx.Error(e)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return err
+	err = tx.completeErr(qctx, done)
+	return done, err
+}
+
+// Begin calls BeginTx with context.Background and no options.
+func (tx *Tx) Begin() (*Tx, error) {
+	return tx.BeginTx(context.Background(), nil)
+}
+
+// BeginTx creates a nested transaction on a savepoint with a generated name.
+// Options are not supported for nested transactions and must be nil. See
+// Savepoint for details.
+func (tx *Tx) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if opts != nil {
+		err := errors.New("sqlm: nested transactions do not support options")
+		return nil, newError(FN_Savepoint, SRC_Transaction, "", nil, time.Now(), err)
+	}
+	return tx.Savepoint(ctx, fmt.Sprintf("sqlm_sp_%d", tx.seq.Add(1)))
+}
+
+// Savepoint creates a savepoint in the transaction and returns a nested
+// transaction on it. Committing the nested transaction releases the savepoint
+// and rolling it back rolls back to the savepoint. The statement is generated
+// by the dialect of the database and runs the handlers of FN_Savepoint. The
+// nested transaction inherits the middlewares of the transaction.
+func (tx *Tx) Savepoint(ctx context.Context, name string) (*Tx, error) {
+	if err := checkIdent(name); err != nil {
+		return nil, newError(FN_Savepoint, SRC_Transaction, "", nil, time.Now(), err)
+	}
+
+	query := tx.db.dial.Savepoint(name)
+	mdws := tx.mdws.fnHndl(FN_Savepoint)
+	if len(mdws) == 0 {
+		start := time.Now()
+		if _, err := tx.tx.ExecContext(ctx, query); err != nil {
+			return nil, newError(FN_Savepoint, SRC_Transaction, query, nil, start, err)
+		} else {
+			return tx.nested(name), nil
+		}
+	}
+
+	var err error
+	qctx := newContext(ctx, FN_Savepoint, SRC_Transaction, query, nil, tx.mdws, mdws)
+	qctx.fn = func() {
+		if _, e := tx.tx.ExecContext(qctx.ctx, qctx.Query); e != nil {
+			qctx.Error(e)
+		} else {
+			qctx.tx = tx.nested(name)
+		}
+	}
+
+	qctx.Next()
+	err = qctx.resultErr(qctx.tx != nil)
+	return qctx.tx, err
+}
+
+// savepointExec executes a statement completing a nested transaction, running
+// the handlers of a sql function, and sets the state of the transaction.
+// Empty statements are not executed, but the handlers still run. It returns
+// whether the statement was executed and completed the transaction.
+func (tx *Tx) savepointExec(
+	ctx context.Context,
+	fn Function,
+	query string,
+	st TxState,
+) (bool, error) {
+	exec := func(ctx context.Context, query string) (bool, error) {
+		return tx.complete(st, func() error {
+			if query == "" {
+				return nil
+			}
+			_, err := tx.tx.ExecContext(ctx, query)
+			return err
+		})
+	}
+
+	mdws := tx.mdws.fnHndl(fn)
+	if len(mdws) == 0 {
+		start := time.Now()
+		done, err := exec(ctx, query)
+		return done, newError(fn, SRC_Transaction, query, nil, start, err)
+	}
+
+	var err error
+	var done bool
+	qctx := newContext(ctx, fn, SRC_Transaction, query, nil, tx.mdws, mdws)
+	qctx.fn = func() {
+		var e error
+		if done, e = exec(qctx.ctx, qctx.Query); e != nil {
+			qctx.Error(e)
+		}
+	}
+
+	qctx.Next()
+	err = tx.completeErr(qctx, done)
+	return done, err
 }
 
 // Exec calls ExecContext with context.Background, query and args.
@@ -78,25 +506,34 @@ func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
 // ExecContext executes a query without returning any rows The args are for any
 // placeholder parameters in the query. It calls sql.ExecContext.
 func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tx.execContext(ctx, query, args, false)
+}
+
+// execContext executes the query on the transaction. The ambient flag
+// reports whether the call was routed from a database object to a transaction
+// stored in the context.Context.
+func (tx *Tx) execContext(ctx context.Context, query string, args []any, ambient bool) (sql.Result, error) {
 	mdws := tx.mdws.fnHndl(FN_Exec)
 	if len(mdws) == 0 {
-		return tx.tx.ExecContext(ctx, query, args...)
+		start := time.Now()
+		res, err := tx.tx.ExecContext(ctx, query, args...)
+		return res, newError(FN_Exec, SRC_Transaction, query, args, start, err)
 	}
 
-	var res sql.Result
 	var err error
-	qctx := newContext(ctx, FN_Exec, SRC_Transaction, query, args, mdws)
+	qctx := newContext(ctx, FN_Exec, SRC_Transaction, query, args, tx.mdws, mdws)
+	qctx.ambient = ambient
 	qctx.fn = func() {
-		if r, e := tx.tx.ExecContext(ctx, qctx.Query, qctx.Args...); e != nil {
+		if r, e := tx.tx.ExecContext(qctx.ctx, qctx.Query, qctx.Args...); e != nil {
 			qctx.Error(e)
 		} else {
-			res = r
+			qctx.result = r
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return res, err
+	err = qctx.err()
+	return qctx.execResult(), err
 }
 
 // Prepare calls PrepareContext with context.Background and query.
@@ -106,69 +543,121 @@ func (tx *Tx) Prepare(query string) (*Stmt, error) {
 
 // PrepareContext creates a prepared statement for later queries or executions.
 // It calls sql.PrepareContext and stores a *sql.Stmt object internally. The
-// statement inherits the middlewares of the database object.
+// statement inherits the middlewares of the transaction.
 func (tx *Tx) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	return tx.prepareContext(ctx, query, false)
+}
+
+// prepareContext prepares the statement on the transaction. The ambient flag
+// reports whether the call was routed from a database object to a transaction
+// stored in the context.Context.
+func (tx *Tx) prepareContext(ctx context.Context, query string, ambient bool) (*Stmt, error) {
 	mdws := tx.mdws.fnHndl(FN_Prepare)
 	if len(mdws) == 0 {
+		start := time.Now()
 		if sqlstmt, err := tx.tx.PrepareContext(ctx, query); err != nil {
-			return nil, err
+			return nil, newError(FN_Prepare, SRC_Transaction, query, nil, start, err)
 		} else {
-			return &Stmt{sqlstmt, tx.mdws, query}, nil
+			return &Stmt{sqlstmt, newScope(tx.mdws, SRC_Statement), query}, nil
 		}
 	}
 
-	var stmt *Stmt
 	var err error
-	qctx := newContext(ctx, FN_Prepare, SRC_Transaction, query, nil, mdws)
+	qctx := newContext(ctx, FN_Prepare, SRC_Transaction, query, nil, tx.mdws, mdws)
+	qctx.ambient = ambient
 	qctx.fn = func() {
-		if s, e := tx.tx.PrepareContext(ctx, qctx.Query); e != nil {
+		if s, e := tx.tx.PrepareContext(qctx.ctx, qctx.Query); e != nil {
 			qctx.Error(e)
 		} else {
-			stmt = &Stmt{s, tx.mdws, query}
+			qctx.stmt = &Stmt{s, newScope(tx.mdws, SRC_Statement), query}
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return stmt, err
+	err = qctx.resultErr(qctx.stmt != nil)
+	return qctx.stmt, err
 }
 
 // Query calls QueryContext with context.Background, query and args.
-func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+func (tx *Tx) Query(query string, args ...any) (*Rows, error) {
 	return tx.QueryContext(context.Background(), query, args...)
 }
 
 // QueryContext executes a query that returns rows, typically a SELECT.
 // The args are for any placeholder parameters in the query. It calls
 // sql.QueryContext
-func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*Rows, error) {
+	return tx.queryContext(ctx, query, args, false)
+}
+
+// queryContext executes the query on the transaction. The ambient flag
+// reports whether the call was routed from a database object to a transaction
+// stored in the context.Context.
+func (tx *Tx) queryContext(ctx context.Context, query string, args []any, ambient bool) (*Rows, error) {
 	mdws := tx.mdws.fnHndl(FN_Query)
 	if len(mdws) == 0 {
-		return tx.tx.QueryContext(ctx, query, args...)
+		start := time.Now()
+		if sqlrows, err := tx.tx.QueryContext(ctx, query, args...); err != nil {
+			return nil, newError(FN_Query, SRC_Transaction, query, args, start, err)
+		} else {
+			return newRows(ctx, sqlrows, newScope(tx.mdws, SRC_Rows), query), nil
+		}
 	}
 
-	var rows *sql.Rows
 	var err error
-	qctx := newContext(ctx, FN_Query, SRC_Transaction, query, args, mdws)
+	qctx := newContext(ctx, FN_Query, SRC_Transaction, query, args, tx.mdws, mdws)
+	qctx.ambient = ambient
 	qctx.fn = func() {
-		if r, e := tx.tx.QueryContext(ctx, qctx.Query, qctx.Args...); e != nil {
+		if r, e := tx.tx.QueryContext(qctx.ctx, qctx.Query, qctx.Args...); e != nil {
 			qctx.Error(e)
 		} else {
-			rows = r
+			qctx.rows = newRows(qctx.ctx, r, newScope(tx.mdws, SRC_Rows), qctx.Query)
 		}
 	}
 
 	qctx.Next()
-	err = qctx.fsterr()
-	return rows, err
+	err = qctx.resultErr(qctx.rows != nil)
+	return qctx.rows, err
 }
 
-// TODO
-func (tx *Tx) Stmt(stmt *Stmt) *Stmt {
-	panic("unimplemented")
+// QueryRow calls QueryRowContext with context.Background, query and args.
+func (tx *Tx) QueryRow(query string, args ...any) *Row {
+	return tx.QueryRowContext(context.Background(), query, args...)
 }
 
-// TODO
-func (tx *Tx) StmtContext(ctx context.Context, stmt *Stmt) *Stmt {
-	panic("unimplemented")
+// QueryRowContext executes a query that is expected to return a single row.
+// It calls QueryContext, so it runs the handlers of FN_Query. Errors are
+// deferred until the row's Scan method is called.
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *Row {
+	return newRow(tx.QueryContext(ctx, query, args...))
+}
+
+// Stmt calls StmtContext with context.Background and stmt.
+func (tx *Tx) Stmt(stmt *Stmt) (*Stmt, error) {
+	return tx.StmtContext(context.Background(), stmt)
+}
+
+// StmtContext returns a transaction-specific prepared statement from an
+// existing statement. It calls sql.StmtContext and runs the handlers of
+// FN_Prepare. The statement keeps the query string of the existing statement
+// and inherits the middlewares of the transaction. Unlike sql.Tx.StmtContext,
+// it returns the errors of the handlers of FN_Prepare. Errors of preparing the
+// statement on the transaction's connection are returned when it is used.
+func (tx *Tx) StmtContext(ctx context.Context, stmt *Stmt) (*Stmt, error) {
+	mdws := tx.mdws.fnHndl(FN_Prepare)
+	if len(mdws) == 0 {
+		sqlstmt := tx.tx.StmtContext(ctx, stmt.st)
+		return &Stmt{sqlstmt, newScope(tx.mdws, SRC_Statement), stmt.query}, nil
+	}
+
+	var err error
+	qctx := newContext(ctx, FN_Prepare, SRC_Transaction, stmt.query, nil, tx.mdws, mdws)
+	qctx.fn = func() {
+		s := tx.tx.StmtContext(qctx.ctx, stmt.st)
+		qctx.stmt = &Stmt{s, newScope(tx.mdws, SRC_Statement), stmt.query}
+	}
+
+	qctx.Next()
+	err = qctx.resultErr(qctx.stmt != nil)
+	return qctx.stmt, err
 }
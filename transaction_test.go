@@ -0,0 +1,607 @@
+package sqlm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTxState(t *testing.T) {
+	errCommit := errors.New("commit failed")
+
+	tests := []struct {
+		name      string
+		commitErr error
+		ops       []string
+		errs      []error
+		state     TxState
+		calls     []string
+		handled   int
+	}{
+		{
+			name:    "commit",
+			ops:     []string{"commit"},
+			errs:    []error{nil},
+			state:   TX_Committed,
+			calls:   []string{"begin", "commit"},
+			handled: 1,
+		},
+		{
+			name:    "rollback after commit",
+			ops:     []string{"commit", "rollback"},
+			errs:    []error{nil, nil},
+			state:   TX_Committed,
+			calls:   []string{"begin", "commit"},
+			handled: 1,
+		},
+		{
+			name:    "commit twice",
+			ops:     []string{"commit", "commit"},
+			errs:    []error{nil, nil},
+			state:   TX_Committed,
+			calls:   []string{"begin", "commit"},
+			handled: 1,
+		},
+		{
+			name:    "commit after rollback",
+			ops:     []string{"rollback", "commit"},
+			errs:    []error{nil, sql.ErrTxDone},
+			state:   TX_RolledBack,
+			calls:   []string{"begin", "rollback"},
+			handled: 1,
+		},
+		{
+			name:    "rollback twice",
+			ops:     []string{"rollback", "rollback"},
+			errs:    []error{nil, nil},
+			state:   TX_RolledBack,
+			calls:   []string{"begin", "rollback"},
+			handled: 1,
+		},
+		{
+			name:      "failed commit",
+			commitErr: errCommit,
+			ops:       []string{"commit", "rollback", "commit"},
+			errs:      []error{errCommit, nil, sql.ErrTxDone},
+			state:     TX_Failed,
+			calls:     []string{"begin", "commit"},
+			handled:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.commitErr = tt.commitErr
+
+			handled := 0
+			db.Use(func(ctx context.Context, qctx *Context) {
+				handled++
+				qctx.Next()
+			}, []Function{FN_Commit, FN_Rollback})
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := tx.State(); s != TX_Active {
+				t.Fatalf("got state %d after begin, want active", s)
+			}
+
+			for i, op := range tt.ops {
+				var err error
+				if op == "commit" {
+					err = tx.Commit()
+				} else {
+					err = tx.Rollback()
+				}
+				if !errors.Is(err, tt.errs[i]) || (tt.errs[i] == nil && err != nil) {
+					t.Fatalf("%s: got error %v, want %v", op, err, tt.errs[i])
+				}
+			}
+
+			if s := tx.State(); s != tt.state {
+				t.Fatalf("got state %d, want %d", s, tt.state)
+			}
+			if calls := st.take(); !reflect.DeepEqual(calls, tt.calls) {
+				t.Fatalf("got calls %q, want %q", calls, tt.calls)
+			}
+			if handled != tt.handled {
+				t.Fatalf("got %d handled calls, want %d", handled, tt.handled)
+			}
+		})
+	}
+}
+
+func TestTxPanickingHandler(t *testing.T) {
+	db, st := openFake(t)
+	db.Use(func(ctx context.Context, qctx *Context) {
+		panic("handler failed")
+	}, []Function{FN_Commit})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		func() {
+			defer func() {
+				recover()
+			}()
+			tx.Commit()
+		}()
+		if err := tx.Rollback(); err != nil {
+			t.Error(err)
+		}
+		if s := tx.State(); s != TX_RolledBack {
+			t.Errorf("got state %d, want rolled back", s)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("WithTx did not re-panic")
+				}
+			}()
+			db.WithTx(context.Background(), nil, func(tx *Tx) error {
+				return nil
+			})
+		}()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("completing the transaction after a panic blocked")
+	}
+
+	want := []string{"begin", "rollback", "begin", "rollback"}
+	if calls := st.take(); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("got calls %q, want %q", calls, want)
+	}
+}
+
+func TestTxAbortCommit(t *testing.T) {
+	db, st := openFake(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx.Use(func(ctx context.Context, qctx *Context) {
+		qctx.Abort()
+		if err := tx.Rollback(); err != nil {
+			t.Error(err)
+		}
+	}, []Function{FN_Commit})
+
+	if err := tx.Commit(); !errors.Is(err, sql.ErrTxDone) {
+		t.Fatalf("got error %v, want %v", err, sql.ErrTxDone)
+	}
+	if s := tx.State(); s != TX_RolledBack {
+		t.Fatalf("got state %d, want rolled back", s)
+	}
+
+	want := []string{"begin", "rollback"}
+	if calls := st.take(); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("got calls %q, want %q", calls, want)
+	}
+}
+
+func TestTxAbortActive(t *testing.T) {
+	tests := []struct {
+		name   string
+		fn     Function
+		nested bool
+		commit bool
+	}{
+		{name: "commit", fn: FN_Commit, commit: true},
+		{name: "rollback", fn: FN_Rollback},
+		{name: "release", fn: FN_Release, nested: true, commit: true},
+		{name: "rollback to", fn: FN_RollbackTo, nested: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := openFake(t)
+			db.Use(func(ctx context.Context, qctx *Context) {
+				qctx.Abort()
+			}, []Function{tt.fn})
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tx.Transaction().Rollback()
+			if tt.nested {
+				if tx, err = tx.Begin(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.commit {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			if !errors.Is(err, ErrNoResult) {
+				t.Fatalf("got error %v, want %v", err, ErrNoResult)
+			}
+			if s := tx.State(); s != TX_Active {
+				t.Fatalf("got state %d, want active", s)
+			}
+		})
+	}
+}
+
+func TestWithTxIncompleteCommit(t *testing.T) {
+	errHandler := errors.New("handler failed")
+
+	tests := []struct {
+		name string
+		hndl func(ctx context.Context, qctx *Context)
+		err  error
+	}{
+		{
+			name: "error",
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Error(errHandler)
+			},
+			err: errHandler,
+		},
+		{
+			name: "abort",
+			hndl: func(ctx context.Context, qctx *Context) {
+				qctx.Abort()
+			},
+			err: ErrNoResult,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			db.Use(tt.hndl, []Function{FN_Commit})
+
+			var tx *Tx
+			err := db.WithTx(context.Background(), nil, func(t *Tx) error {
+				tx = t
+				return nil
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if s := tx.State(); s != TX_RolledBack {
+				t.Fatalf("got state %d, want rolled back", s)
+			}
+			if n := db.Database().Stats().InUse; n != 0 {
+				t.Fatalf("got %d connections in use, want 0", n)
+			}
+
+			want := []string{"begin", "rollback"}
+			if calls := st.take(); !reflect.DeepEqual(calls, want) {
+				t.Fatalf("got calls %q, want %q", calls, want)
+			}
+		})
+	}
+}
+
+func TestTxNested(t *testing.T) {
+	db, st := openFake(t)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sp1, err := tx.Savepoint(context.Background(), "sp1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp1.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	sp2, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp2.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	sp3, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp3.Commit(); err != nil {
+		t.Fatalf("committing a savepoint of a committed transaction: %v", err)
+	}
+
+	states := []TxState{sp1.State(), sp2.State(), sp3.State(), tx.State()}
+	want := []TxState{TX_Committed, TX_RolledBack, TX_Committed, TX_Committed}
+	if !reflect.DeepEqual(states, want) {
+		t.Fatalf("got states %v, want %v", states, want)
+	}
+
+	calls := []string{
+		"begin",
+		"exec SAVEPOINT sp1",
+		"exec RELEASE SAVEPOINT sp1",
+		"exec SAVEPOINT sqlm_sp_1",
+		"exec ROLLBACK TO SAVEPOINT sqlm_sp_1",
+		"exec SAVEPOINT sqlm_sp_2",
+		"commit",
+	}
+	if got := st.take(); !reflect.DeepEqual(got, calls) {
+		t.Fatalf("got calls %q, want %q", got, calls)
+	}
+}
+
+func TestTxHooks(t *testing.T) {
+	errCommit := errors.New("commit failed")
+	errHook := errors.New("hook failed")
+
+	tests := []struct {
+		name      string
+		commitErr error
+		hookErr   error
+		rollback  bool
+		want      []string
+		err       []error
+		state     TxState
+	}{
+		{
+			name:  "commit",
+			want:  []string{"handler", "commit 1", "commit 2"},
+			state: TX_Committed,
+		},
+		{
+			name:     "rollback",
+			rollback: true,
+			want:     []string{"rollback 1 <nil>", "rollback 2 <nil>"},
+			state:    TX_RolledBack,
+		},
+		{
+			name:      "failed commit",
+			commitErr: errCommit,
+			want:      []string{"handler", "rollback 1 commit failed", "rollback 2 commit failed"},
+			err:       []error{errCommit},
+			state:     TX_Failed,
+		},
+		{
+			name:    "failed hook",
+			hookErr: errHook,
+			want:    []string{"handler", "commit 1", "commit 2"},
+			state:   TX_Committed,
+		},
+		{
+			name:      "failed hook after failed commit",
+			commitErr: errCommit,
+			hookErr:   errHook,
+			want:      []string{"handler", "rollback 1 commit failed", "rollback 2 commit failed"},
+			err:       []error{errCommit},
+			state:     TX_Failed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, st := openFake(t)
+			st.commitErr = tt.commitErr
+
+			got := []string{}
+			db.Use(func(ctx context.Context, qctx *Context) {
+				qctx.Next()
+				got = append(got, "handler")
+			}, []Function{FN_Commit})
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range []string{"1", "2"} {
+				n := n
+				tx.OnCommit(func(ctx context.Context) error {
+					got = append(got, "commit "+n)
+					return tt.hookErr
+				})
+				tx.OnRollback(func(ctx context.Context, err error) error {
+					cause := "<nil>"
+					if serr := (*Error)(nil); errors.As(err, &serr) {
+						cause = serr.Err.Error()
+					}
+					got = append(got, "rollback "+n+" "+cause)
+					return tt.hookErr
+				})
+			}
+
+			if tt.rollback {
+				err = tx.Rollback()
+			} else {
+				err = tx.Commit()
+			}
+			for _, e := range tt.err {
+				if !errors.Is(err, e) {
+					t.Fatalf("got error %v, want %v", err, e)
+				}
+			}
+			if len(tt.err) == 0 && err != nil {
+				t.Fatal(err)
+			}
+
+			var herr *HookError
+			if errors.As(err, &herr) {
+				t.Fatalf("got hook error %v from the completion", err)
+			}
+			if errors.As(tx.HookErrors(), &herr) != (tt.hookErr != nil) {
+				t.Fatalf("got hook errors %v, want %t", tx.HookErrors(), tt.hookErr != nil)
+			} else if herr != nil && (len(herr.Errs) != 2 || !errors.Is(herr, tt.hookErr)) {
+				t.Fatalf("got hook errors %v, want two %v", herr, tt.hookErr)
+			}
+			if s := tx.State(); s != tt.state {
+				t.Fatalf("got state %d, want %d", s, tt.state)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+
+			// Completing the transaction again does not run the hooks.
+			tx.Rollback()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q after rollback, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTxNestedHooks(t *testing.T) {
+	for _, commit := range []bool{true, false} {
+		db, _ := openFake(t)
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := []string{}
+		hooks := func(tx *Tx, name string) {
+			tx.OnCommit(func(ctx context.Context) error {
+				got = append(got, name+" commit")
+				return nil
+			})
+			tx.OnRollback(func(ctx context.Context, err error) error {
+				got = append(got, name+" rollback")
+				return nil
+			})
+		}
+
+		released, err := tx.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hooks(released, "released")
+		if err := released.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Fatalf("got %q after release, want no hooks", got)
+		}
+
+		undone, err := tx.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hooks(undone, "undone")
+		if err := undone.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"undone rollback"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q after rollback to savepoint, want %q", got, want)
+		}
+
+		want := []string{"undone rollback", "released commit"}
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+			want[1] = "released rollback"
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWithTxHookErrors(t *testing.T) {
+	db, _ := openFake(t)
+	errHook := errors.New("hook failed")
+
+	var tx *Tx
+	err := db.WithTx(context.Background(), nil, func(t *Tx) error {
+		tx = t
+		tx.OnCommit(func(ctx context.Context) error {
+			return errHook
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := tx.State(); s != TX_Committed {
+		t.Fatalf("got state %d, want committed", s)
+	}
+	if err := tx.HookErrors(); !errors.Is(err, errHook) {
+		t.Fatalf("got hook errors %v, want %v", err, errHook)
+	}
+}
+
+func TestTxStmt(t *testing.T) {
+	db, st := openFake(t)
+	st.result("SELECT", []string{"id"}, []driver.Value{int64(1)})
+
+	stmt, err := db.Prepare("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	got := []string{}
+	tx.Use(func(ctx context.Context, qctx *Context) {
+		got = append(got, qctx.Function().String()+" "+qctx.Source().String()+" "+qctx.Query)
+		qctx.Next()
+	}, []Function{FN_Prepare, FN_Query})
+
+	for _, fn := range []func() (*Stmt, error){
+		func() (*Stmt, error) { return tx.Stmt(stmt) },
+		func() (*Stmt, error) { return tx.StmtContext(context.Background(), stmt) },
+	} {
+		txStmt, err := fn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var id int64
+		if err := txStmt.QueryRow().Scan(&id); err != nil || id != 1 {
+			t.Fatalf("got %d and error %v, want 1", id, err)
+		}
+		txStmt.Close()
+	}
+
+	want := []string{
+		"prepare transaction SELECT",
+		"query statement SELECT",
+		"prepare transaction SELECT",
+		"query statement SELECT",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	tx.Use(func(ctx context.Context, qctx *Context) {
+		qctx.Abort()
+	}, []Function{FN_Prepare})
+	if _, err := tx.Stmt(stmt); !errors.Is(err, ErrNoResult) {
+		t.Fatalf("got error %v, want %v", err, ErrNoResult)
+	}
+}